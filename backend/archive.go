@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// ArchiveSuffix is appended to the name of an archive for the directory
+// exposing its members, e.g. Mean.zip.d/ next to Mean.zip. The archive stays
+// a file, and a file and a directory can't share a name.
+const ArchiveSuffix = ".d"
+
+// maxCachedArchives limits the number of archives kept in memory.
+const maxCachedArchives = 8
+
+// Archive wraps a backend and exposes zip and gzip files also as
+// directories of their members.
+type Archive struct {
+	backend Backend
+
+	mu    sync.Mutex
+	cache map[string]*archive
+	order []string
+}
+
+// archive is the index of a downloaded archive file.
+type archive struct {
+	// entry of the archive file itself, to detect changes
+	entry types.FSEntry
+	// dirs maps member directories, "." for the top level, to entries
+	dirs map[string][]types.FSEntry
+	// files maps member paths to their content
+	files map[string]func() (io.ReadCloser, error)
+}
+
+func NewArchive(b Backend) *Archive {
+	return &Archive{backend: b, cache: make(map[string]*archive)}
+}
+
+var _ = (Backend)((*Archive)(nil))
+
+// isArchive reports if name has an extension supported as archive.
+func isArchive(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".zip" || ext == ".gz"
+}
+
+// splitArchive splits name into the path of the archive and the member path
+// within it. ok is false if name is not located inside an archive directory.
+func splitArchive(name string) (archivePath, member string, ok bool) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for idx, part := range parts {
+		if base := strings.TrimSuffix(part, ArchiveSuffix); base != part && isArchive(base) {
+			archivePath = "/" + path.Join(append(parts[:idx:idx], base)...)
+			member = path.Join(parts[idx+1:]...)
+			if member == "" {
+				member = "."
+			}
+			return archivePath, member, true
+		}
+	}
+	return "", "", false
+}
+
+func (b *Archive) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	if archivePath, member, ok := splitArchive(name); ok {
+		a, err := b.load(ctx, archivePath)
+		if err != nil {
+			return nil, err
+		}
+		entries, ok := a.dirs[member]
+		if !ok {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+		}
+		return entries, nil
+	}
+
+	entries, err := b.backend.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Filename != "" && isArchive(entry.Filename) {
+			entries = append(entries, archiveDirEntry(entry))
+		}
+	}
+	return entries, nil
+}
+
+// archiveDirEntry returns the directory entry for the archive file entry.
+func archiveDirEntry(entry types.FSEntry) types.FSEntry {
+	return types.FSEntry{DirectoryName: entry.Filename + ArchiveSuffix, Timestamp: entry.Timestamp, Device: entry.Device}
+}
+
+func (b *Archive) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	archivePath, member, ok := splitArchive(name)
+	if !ok {
+		return b.backend.Stat(ctx, name)
+	}
+	if member == "." {
+		entry, err := b.backend.Stat(ctx, archivePath)
+		if err != nil {
+			return types.FSEntry{}, err
+		}
+		return archiveDirEntry(entry), nil
+	}
+
+	a, err := b.load(ctx, archivePath)
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	base := path.Base(member)
+	for _, entry := range a.dirs[path.Dir(member)] {
+		if EntryName(entry) == base {
+			return entry, nil
+		}
+	}
+	return types.FSEntry{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (b *Archive) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	archivePath, member, ok := splitArchive(name)
+	if !ok {
+		return b.backend.Open(ctx, name)
+	}
+	a, err := b.load(ctx, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	open, ok := a.files[member]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return open()
+}
+
+// load returns the index of the archive at archivePath. Archives are cached
+// as long as size and timestamp of the remote file do not change.
+func (b *Archive) load(ctx context.Context, archivePath string) (*archive, error) {
+	entry, err := b.backend.Stat(ctx, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Filename == "" {
+		return nil, &fs.PathError{Op: "open", Path: archivePath, Err: fs.ErrNotExist}
+	}
+
+	b.mu.Lock()
+	a, ok := b.cache[archivePath]
+	b.mu.Unlock()
+	if ok && a.entry == entry {
+		return a, nil
+	}
+
+	rc, err := b.backend.Open(ctx, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(path.Ext(archivePath)) == ".zip" {
+		a, err = indexZip(entry, content)
+	} else {
+		a, err = indexGzip(entry, content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive %s: %v", archivePath, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.cache[archivePath]; !ok {
+		b.order = append(b.order, archivePath)
+	}
+	b.cache[archivePath] = a
+	for len(b.order) > maxCachedArchives {
+		delete(b.cache, b.order[0])
+		b.order = b.order[1:]
+	}
+	return a, nil
+}
+
+func newArchive(entry types.FSEntry) *archive {
+	return &archive{
+		entry: entry,
+		dirs:  map[string][]types.FSEntry{".": {}},
+		files: make(map[string]func() (io.ReadCloser, error)),
+	}
+}
+
+// addDir adds directory dir and its parents to the index.
+func (a *archive) addDir(dir string) {
+	if _, ok := a.dirs[dir]; ok {
+		return
+	}
+	a.dirs[dir] = []types.FSEntry{}
+	parent := path.Dir(dir)
+	a.addDir(parent)
+	entry := types.FSEntry{DirectoryName: path.Base(dir), Timestamp: a.entry.Timestamp, Device: a.entry.Device}
+	a.dirs[parent] = append(a.dirs[parent], entry)
+}
+
+func indexZip(entry types.FSEntry, content []byte) (*archive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	a := newArchive(entry)
+	for _, f := range zr.File {
+		name := path.Clean(strings.TrimPrefix(f.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			a.addDir(name)
+			continue
+		}
+		a.addDir(path.Dir(name))
+		member := types.FSEntry{Filename: path.Base(name), Timestamp: uint64(f.Modified.Unix()), Size: f.UncompressedSize64, Device: entry.Device}
+		a.dirs[path.Dir(name)] = append(a.dirs[path.Dir(name)], member)
+		a.files[name] = f.Open
+	}
+	return a, nil
+}
+
+func indexGzip(entry types.FSEntry, content []byte) (*archive, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	// The uncompressed size is only known after decompression
+	size, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return nil, err
+	}
+
+	a := newArchive(entry)
+	name := path.Base(zr.Name)
+	if zr.Name == "" || !fs.ValidPath(name) {
+		name = strings.TrimSuffix(entry.Filename, path.Ext(entry.Filename))
+	}
+	timestamp := entry.Timestamp
+	if !zr.ModTime.IsZero() {
+		timestamp = uint64(zr.ModTime.Unix())
+	}
+	a.dirs["."] = []types.FSEntry{{Filename: name, Timestamp: timestamp, Size: uint64(size), Device: entry.Device}}
+	a.files[name] = func() (io.ReadCloser, error) {
+		return gzip.NewReader(bytes.NewReader(content))
+	}
+	return a, nil
+}
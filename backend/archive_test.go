@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func archiveFS() fstest.MapFS {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.Create("Mean.csv")
+	io.WriteString(w, "mean content\n")
+	w, _ = zw.Create("sub/dir/Yield.csv")
+	io.WriteString(w, "yield content\n")
+	zw.Close()
+
+	var gbuf bytes.Buffer
+	gw := gzip.NewWriter(&gbuf)
+	io.WriteString(gw, "event content\n")
+	gw.Close()
+
+	mtime := time.Unix(1684094403, 0)
+	return fstest.MapFS{
+		"DATA/Mean.zip":      &fstest.MapFile{Data: zbuf.Bytes(), ModTime: mtime},
+		"DATA/Events.csv.gz": &fstest.MapFile{Data: gbuf.Bytes(), ModTime: mtime},
+		"DATA/plain.txt":     &fstest.MapFile{Data: []byte("plain\n"), ModTime: mtime},
+	}
+}
+
+func readAll(t *testing.T, b Backend, name string) string {
+	rc, err := b.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Open %s returned an error: %v", name, err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	return string(content)
+}
+
+func TestArchiveList(t *testing.T) {
+	b := NewArchive(NewMemory(archiveFS()))
+	ctx := context.Background()
+
+	entries, err := b.List(ctx, "/DATA")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[EntryName(entry)] = entry.DirectoryName != ""
+	}
+	for name, isDir := range map[string]bool{"Mean.zip": false, "Mean.zip.d": true, "Events.csv.gz": false, "Events.csv.gz.d": true, "plain.txt": false} {
+		if d, ok := names[name]; !ok || d != isDir {
+			t.Errorf("Expected entry %s (dir: %v) in %v", name, isDir, names)
+		}
+	}
+
+	entries, err = b.List(ctx, "/DATA/Mean.zip.d")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Filename != "Mean.csv" || entries[0].Size != 13 || entries[1].DirectoryName != "sub" {
+		t.Errorf("Invalid archive listing: %+v", entries)
+	}
+
+	entries, err = b.List(ctx, "/DATA/Mean.zip.d/sub/dir")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Filename != "Yield.csv" {
+		t.Errorf("Invalid archive listing: %+v", entries)
+	}
+}
+
+func TestArchiveStatOpen(t *testing.T) {
+	b := NewArchive(NewMemory(archiveFS()))
+	ctx := context.Background()
+
+	entry, err := b.Stat(ctx, "/DATA/Mean.zip.d")
+	if err != nil || entry.DirectoryName != "Mean.zip.d" || entry.Timestamp != 1684094403 {
+		t.Errorf("Invalid archive directory entry: %+v, %v", entry, err)
+	}
+	entry, err = b.Stat(ctx, "/DATA/Mean.zip.d/sub/dir/Yield.csv")
+	if err != nil || entry.Filename != "Yield.csv" {
+		t.Errorf("Invalid member entry: %+v, %v", entry, err)
+	}
+	if _, err := b.Stat(ctx, "/DATA/Mean.zip.d/missing.csv"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+
+	if content := readAll(t, b, "/DATA/Mean.zip.d/sub/dir/Yield.csv"); content != "yield content\n" {
+		t.Errorf("Invalid member content: %q", content)
+	}
+	if content := readAll(t, b, "/DATA/Events.csv.gz.d/Events.csv"); content != "event content\n" {
+		t.Errorf("Invalid member content: %q", content)
+	}
+	if content := readAll(t, b, "/DATA/plain.txt"); content != "plain\n" {
+		t.Errorf("Invalid content: %q", content)
+	}
+}
+
+func TestSplitArchive(t *testing.T) {
+	for name, expected := range map[string][2]string{
+		"/DATA/Mean.zip.d":         {"/DATA/Mean.zip", "."},
+		"/DATA/Mean.zip.d/a/b.csv": {"/DATA/Mean.zip", "a/b.csv"},
+		"/Events.GZ.d/Events":      {"/Events.GZ", "Events"},
+	} {
+		archivePath, member, ok := splitArchive(name)
+		if !ok || archivePath != expected[0] || member != expected[1] {
+			t.Errorf("splitArchive(%q) = %q, %q, %v", name, archivePath, member, ok)
+		}
+	}
+	for _, name := range []string{"/", "/DATA/Mean.zip", "/DATA/plain.d/x"} {
+		if _, _, ok := splitArchive(name); ok {
+			t.Errorf("splitArchive(%q) unexpectedly matched", name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Backend provides read access to a file tree. Paths are slash separated and
+// absolute, the root directory is "/". Entries use the same representation
+// as returned by the getFS.json endpoint of the inverter.
+type Backend interface {
+	// List returns the entries of directory name.
+	List(ctx context.Context, name string) ([]types.FSEntry, error)
+	// Stat returns the entry describing name. Errors wrap fs.ErrNotExist
+	// if name does not exist.
+	Stat(ctx context.Context, name string) (types.FSEntry, error)
+	// Open returns the content of file name.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// EntryName returns the file or directory name of an entry.
+func EntryName(entry types.FSEntry) string {
+	if entry.DirectoryName != "" {
+		return entry.DirectoryName
+	}
+	return entry.Filename
+}
+
+// rootEntry describes the root directory, which is not part of any listing.
+var rootEntry = types.FSEntry{DirectoryName: "/"}
+
+// HTTP is the backend talking to the web API of an inverter. Expired
+// sessions are renewed by the API.
+type HTTP struct {
+	api *sma.SMAApi
+}
+
+func NewHTTP(api *sma.SMAApi) *HTTP {
+	return &HTTP{api: api}
+}
+
+var _ = (Backend)((*HTTP)(nil))
+
+func (b *HTTP) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	return b.api.GetFS(ctx, name)
+}
+
+// Stat looks up name in the listing of its parent directory, the API has no
+// dedicated endpoint for single entries.
+func (b *HTTP) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	if name == "/" {
+		return rootEntry, nil
+	}
+	entries, err := b.List(ctx, path.Dir(name))
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	base := path.Base(name)
+	for _, entry := range entries {
+		if EntryName(entry) == base {
+			return entry, nil
+		}
+	}
+	return types.FSEntry{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// downloadAttempts is the number of tries of downloads that are truncated or
+// fail with a server error, retryDelay the wait before the second one,
+// doubled for each further one.
+const downloadAttempts = 3
+
+var retryDelay = 200 * time.Millisecond
+
+// SizeMismatchError is returned for downloads of another size than listed.
+type SizeMismatchError struct {
+	Path             string
+	Expected, Actual uint64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("error downloading %s: %d bytes instead of the listed %d", e.Path, e.Actual, e.Expected)
+}
+
+// retryable reports if a download failing with err may succeed when
+// repeated.
+func retryable(err error) bool {
+	var sizeErr *SizeMismatchError
+	var statusErr *sma.StatusError
+	return errors.As(err, &sizeErr) ||
+		errors.As(err, &statusErr) && statusErr.Code >= http.StatusInternalServerError ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Open downloads name and verifies its size against the listing. Files
+// listed with size 0 are not verified, the inverter reports that for some
+// files being written. Truncated downloads and server errors are retried,
+// the listing is refreshed for each attempt as the file may have grown.
+func (b *HTTP) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	var content []byte
+	var err error
+	delay := retryDelay
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		var entry types.FSEntry
+		if entry, err = b.Stat(ctx, name); err != nil {
+			return nil, err
+		}
+		content, err = b.api.Download(ctx, strings.TrimPrefix(name, "/"))
+		if err == nil && entry.Size != 0 && uint64(len(content)) != entry.Size {
+			err = &SizeMismatchError{Path: name, Expected: entry.Size, Actual: uint64(len(content))}
+		}
+		if !retryable(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// FS is a backend serving an io/fs file system.
+type FS struct {
+	fsys fs.FS
+}
+
+func NewFS(fsys fs.FS) *FS {
+	return &FS{fsys: fsys}
+}
+
+// NewMemory returns a backend serving files from memory.
+func NewMemory(files fstest.MapFS) *FS {
+	return NewFS(files)
+}
+
+// NewDir returns a backend serving the local directory dir.
+func NewDir(dir string) *FS {
+	return NewFS(os.DirFS(dir))
+}
+
+var _ = (Backend)((*FS)(nil))
+
+// fsPath converts an absolute backend path to an io/fs path.
+func fsPath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (b *FS) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	content, err := fs.ReadDir(b.fsys, fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]types.FSEntry, len(content))
+	for idx, entry := range content {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries[idx] = entryFromInfo(info)
+	}
+	return entries, nil
+}
+
+func (b *FS) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	if fsPath(name) == "." {
+		return rootEntry, nil
+	}
+	info, err := fs.Stat(b.fsys, fsPath(name))
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	return entryFromInfo(info), nil
+}
+
+func (b *FS) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.fsys.Open(fsPath(name))
+}
+
+func entryFromInfo(info fs.FileInfo) types.FSEntry {
+	if info.IsDir() {
+		return types.FSEntry{DirectoryName: info.Name(), Timestamp: uint64(info.ModTime().Unix())}
+	}
+	return types.FSEntry{Filename: info.Name(), Timestamp: uint64(info.ModTime().Unix()), Size: uint64(info.Size())}
+}
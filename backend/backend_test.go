@@ -0,0 +1,243 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+var testFS = fstest.MapFS{
+	"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: time.Unix(1684094403, 0)},
+	"DIAGNOSE/file2.txt": &fstest.MapFile{Data: []byte("file2.txt content\n"), ModTime: time.Unix(1694580920, 0)},
+	"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n")},
+}
+
+// testBackend checks the behaviour shared by all backends serving testFS
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+
+	entries, err := b.List(ctx, "/DIAGNOSE")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, but got %d", len(entries))
+	}
+
+	entry, err := b.Stat(ctx, "/DIAGNOSE/file2.txt")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if entry.Filename != "file2.txt" || entry.Size != 18 || entry.Timestamp != 1694580920 {
+		t.Errorf("Invalid entry: %+v", entry)
+	}
+
+	entry, err = b.Stat(ctx, "/SYSLOG")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if entry.DirectoryName != "SYSLOG" {
+		t.Errorf("Invalid entry: %+v", entry)
+	}
+
+	if _, err := b.Stat(ctx, "/DIAGNOSE/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+
+	rc, err := b.Open(ctx, "/SYSLOG/blarg")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	if string(content) != "blarg content\n" {
+		t.Errorf("Invalid content: %q", content)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	mock := tests.NewMockServer(testFS)
+	defer mock.Close()
+
+	testBackend(t, NewHTTP(&sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}))
+}
+
+func TestHTTPRenew(t *testing.T) {
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", testFS))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	api := &sma.SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	b := NewHTTP(api)
+
+	mock.ExpireSessions()
+	if _, err := b.List(ctx, "/DIAGNOSE"); err != nil {
+		t.Errorf("List returned an error after session expiry: %v", err)
+	}
+	mock.ExpireSessions()
+	rc, err := b.Open(ctx, "/SYSLOG/blarg")
+	if err != nil {
+		t.Fatalf("Open returned an error after session expiry: %v", err)
+	}
+	rc.Close()
+	if mock.Sessions() != 1 {
+		t.Errorf("Expected 1 session, got %d", mock.Sessions())
+	}
+
+	// the session expires in the middle of listing the tree
+	mock.AddFault(tests.Fault{Path: "/dyn/getFS.json", Expire: true, Skip: 1, Count: 1})
+	for _, dir := range []string{"/", "/DIAGNOSE", "/SYSLOG"} {
+		if _, err := b.List(ctx, dir); err != nil {
+			t.Errorf("List %s returned an error during session expiry: %v", dir, err)
+		}
+	}
+	if mock.Faults() != 0 || mock.Sessions() != 1 {
+		t.Errorf("Expected the session to be renewed once, got %d faults left and %d sessions", mock.Faults(), mock.Sessions())
+	}
+
+	// renewing fails with a changed password
+	mock.ExpireSessions()
+	mock.SetPassword("usr", "changed")
+	var apiErr *sma.APIError
+	if _, err := b.List(ctx, "/DIAGNOSE"); !errors.As(err, &apiErr) || apiErr.Code != 401 {
+		t.Errorf("Expected error 401, got %v", err)
+	}
+}
+
+func TestHTTPIntegrity(t *testing.T) {
+	retryDelay = time.Millisecond
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", testFS))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	api := &sma.SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	b := NewHTTP(api)
+
+	// failures of single attempts are retried
+	for _, fault := range []tests.Fault{
+		{Path: "/fs/", Truncate: true, Count: 1},
+		{Path: "/fs/", Body: "file1", Count: 2},
+		{Path: "/fs/", Status: http.StatusBadGateway, Count: 2},
+	} {
+		mock.AddFault(fault)
+		rc, err := b.Open(ctx, "/DIAGNOSE/file1.txt")
+		if err != nil {
+			t.Errorf("Open returned an error for fault %+v: %v", fault, err)
+			continue
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(content) != "file1.txt content\n" {
+			t.Errorf("Invalid content for fault %+v: %q", fault, content)
+		}
+	}
+
+	mock.AddFault(tests.Fault{Path: "/fs/", Body: "file1", Count: downloadAttempts})
+	var sizeErr *SizeMismatchError
+	if _, err := b.Open(ctx, "/DIAGNOSE/file1.txt"); !errors.As(err, &sizeErr) || sizeErr.Expected != 18 || sizeErr.Actual != 5 {
+		t.Errorf("Expected size mismatch, got %v", err)
+	}
+
+	// the backoff ends with the context
+	retryDelay = time.Hour
+	mock.AddFault(tests.Fault{Path: "/fs/", Truncate: true, Count: 1})
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.Open(cancelCtx, "/DIAGNOSE/file1.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	retryDelay = time.Millisecond
+
+	// client errors are not retried
+	mock.AddFault(tests.Fault{Path: "/fs/", Status: http.StatusNotFound, Count: 1})
+	var statusErr *sma.StatusError
+	if _, err := b.Open(ctx, "/DIAGNOSE/file1.txt"); !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %v", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	virtual := NewMemory(fstest.MapFS{"values.csv": &fstest.MapFile{Data: []byte("a,b\n")}})
+	o := NewOverlay(NewMemory(testFS), "/live", virtual, func() time.Time { return now })
+
+	entries, err := o.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 3 || entries[2].DirectoryName != "live" || entries[2].Timestamp != uint64(now.Unix()) {
+		t.Errorf("Invalid root listing: %+v", entries)
+	}
+	if entries, err := o.List(ctx, "/live"); err != nil || len(entries) != 1 || entries[0].Filename != "values.csv" {
+		t.Errorf("Invalid virtual listing: %+v, %v", entries, err)
+	}
+	if entry, err := o.Stat(ctx, "/live"); err != nil || entry.DirectoryName != "live" {
+		t.Errorf("Invalid virtual root: %+v, %v", entry, err)
+	}
+	if entry, err := o.Stat(ctx, "/live/values.csv"); err != nil || entry.Size != 4 {
+		t.Errorf("Invalid virtual entry: %+v, %v", entry, err)
+	}
+	if entry, err := o.Stat(ctx, "/DIAGNOSE/file1.txt"); err != nil || entry.Size != 18 {
+		t.Errorf("Invalid entry: %+v, %v", entry, err)
+	}
+	rc, err := o.Open(ctx, "/live/values.csv")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "a,b\n" {
+		t.Errorf("Invalid content: %q", content)
+	}
+
+	// errors of the virtual backend report the full path
+	var pathErr *fs.PathError
+	if _, err := o.Stat(ctx, "/live/missing.csv"); !errors.As(err, &pathErr) || pathErr.Path != "/live/missing.csv" {
+		t.Errorf("Expected error for /live/missing.csv, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory(testFS))
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, file := range testFS {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, file.Data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, file.ModTime, file.ModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testBackend(t, NewDir(dir))
+}
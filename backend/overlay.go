@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Overlay adds a virtual directory at root to a backend. Paths below root are
+// served by the virtual backend, relative to root, all others by the wrapped
+// one. Root itself is listed in its parent directory.
+type Overlay struct {
+	backend Backend
+	root    string
+	virtual Backend
+	// now returns the modification time of root
+	now func() time.Time
+}
+
+// NewOverlay returns b with virtual mounted at root. now returns the
+// modification time of root, time.Now if nil.
+func NewOverlay(b Backend, root string, virtual Backend, now func() time.Time) *Overlay {
+	if now == nil {
+		now = time.Now
+	}
+	return &Overlay{backend: b, root: path.Clean("/" + root), virtual: virtual, now: now}
+}
+
+var _ = (Backend)((*Overlay)(nil))
+
+// split returns the path relative to root of a path below root.
+func (o *Overlay) split(name string) (string, bool) {
+	name = path.Clean("/" + name)
+	if name != o.root && !strings.HasPrefix(name, o.root+"/") {
+		return "", false
+	}
+	return path.Join("/", strings.TrimPrefix(name, o.root)), true
+}
+
+// rootEntry describes root.
+func (o *Overlay) rootEntry() types.FSEntry {
+	return types.FSEntry{DirectoryName: path.Base(o.root), Timestamp: uint64(o.now().Unix())}
+}
+
+// absError reports the paths of errors of the virtual backend below root.
+func (o *Overlay) absError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && !strings.HasPrefix(pathErr.Path, o.root+"/") {
+		pathErr.Path = path.Join(o.root, pathErr.Path)
+	}
+	return err
+}
+
+func (o *Overlay) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	if rest, ok := o.split(name); ok {
+		entries, err := o.virtual.List(ctx, rest)
+		return entries, o.absError(err)
+	}
+	entries, err := o.backend.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean("/"+name) == path.Dir(o.root) {
+		entries = append(entries, o.rootEntry())
+	}
+	return entries, nil
+}
+
+func (o *Overlay) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	rest, ok := o.split(name)
+	if !ok {
+		return o.backend.Stat(ctx, name)
+	}
+	if rest == "/" {
+		return o.rootEntry(), nil
+	}
+	entry, err := o.virtual.Stat(ctx, rest)
+	return entry, o.absError(err)
+}
+
+func (o *Overlay) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if rest, ok := o.split(name); ok {
+		rc, err := o.virtual.Open(ctx, rest)
+		return rc, o.absError(err)
+	}
+	return o.backend.Open(ctx, name)
+}
@@ -0,0 +1,74 @@
+// Command sma-mock serves a stand-in for the web interface of SMA inverters,
+// to test integrations without hardware.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/tests"
+)
+
+// faultFlags collect repeated -fault flags.
+type faultFlags []tests.Fault
+
+func (f *faultFlags) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *faultFlags) Set(value string) error {
+	fault, err := tests.ParseFault(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fault)
+	return nil
+}
+
+func main() {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "address to listen on")
+	devices := flags.String("devices", "1901234567", "comma separated serials of the devices")
+	dir := flags.String("dir", "", "directory served as file system of the first device (default: empty)")
+	password := flags.String("password", "0000", "password of the profiles usr and istl")
+	maxSessions := flags.Int("max-sessions", 4, "maximum number of open sessions, 0 for no limit")
+	sessionTTL := flags.Duration("session-ttl", 5*time.Minute, "idle time after which sessions expire, 0 never")
+	latency := flags.Duration("latency", 0, "delay of every response")
+	cert := flags.String("cert", "", "PEM certificate to serve HTTPS with")
+	key := flags.String("key", "", "PEM key of -cert")
+	var faults faultFlags
+	flags.Var(&faults, "fault", "inject a fault: path=action[*count], action being a status code, drop, truncate, malformed, expire or a delay, may be repeated")
+	flags.Parse(os.Args[1:])
+
+	var mockDevices []*tests.MockDevice
+	for idx, name := range strings.Split(*devices, ",") {
+		device := tests.NewMockDevice(strings.TrimSpace(name), fstest.MapFS{})
+		if idx == 0 && *dir != "" {
+			device.Files = os.DirFS(*dir)
+		}
+		mockDevices = append(mockDevices, device)
+	}
+	mock := tests.NewSMAMock(*password, mockDevices...)
+	mock.MaxSessions = *maxSessions
+	mock.SessionTTL = *sessionTTL
+	mock.Latency = *latency
+	for _, fault := range faults {
+		mock.AddFault(fault)
+	}
+
+	var err error
+	if *cert != "" {
+		log.Printf("serving on https://%s/\n", *listen)
+		err = http.ListenAndServeTLS(*listen, *cert, *key, mock)
+	} else {
+		log.Printf("serving on http://%s/\n", *listen)
+		err = http.ListenAndServe(*listen, mock)
+	}
+	log.Fatalf("error serving: %v\n", err)
+}
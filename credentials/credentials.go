@@ -0,0 +1,251 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by providers without credentials for a target.
+// Chains continue with the next provider.
+var ErrNotFound = errors.New("credentials not found")
+
+// DefaultUser is the profile used if a provider only knows the password.
+const DefaultUser = "usr"
+
+// Provider returns the profile and password for the inverter at target,
+// the host name of its URL.
+type Provider interface {
+	Credentials(target string) (user, password string, err error)
+}
+
+// Files reads the profile and password from files. Surrounding whitespace,
+// like a trailing newline, is removed.
+type Files struct {
+	User, Password string
+}
+
+var _ = (Provider)((*Files)(nil))
+
+func (f *Files) Credentials(target string) (string, string, error) {
+	if f.User == "" || f.Password == "" {
+		return "", "", ErrNotFound
+	}
+	user, err := readTrimmed(f.User)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading username from file: %v", err)
+	}
+	password, err := readTrimmed(f.Password)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading password from file: %v", err)
+	}
+	return user, password, nil
+}
+
+func readTrimmed(name string) (string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Env returns the files named by the environment variables SMAFS_USER and
+// SMAFS_PASS.
+func Env() *Files {
+	return &Files{User: os.Getenv("SMAFS_USER"), Password: os.Getenv("SMAFS_PASS")}
+}
+
+// Systemd reads credentials passed by systemd with LoadCredential. The
+// credentials smafs.<target>.user and smafs.<target>.pass take precedence
+// over smafs.user and smafs.pass. The profile is optional.
+type Systemd struct {
+	// Dir is the credentials directory, $CREDENTIALS_DIRECTORY if empty
+	Dir string
+}
+
+var _ = (Provider)((*Systemd)(nil))
+
+func (s *Systemd) Credentials(target string) (string, string, error) {
+	dir := s.Dir
+	if dir == "" {
+		dir = os.Getenv("CREDENTIALS_DIRECTORY")
+	}
+	if dir == "" {
+		return "", "", ErrNotFound
+	}
+	for _, prefix := range []string{"smafs." + target + ".", "smafs."} {
+		password, err := readTrimmed(filepath.Join(dir, prefix+"pass"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", "", fmt.Errorf("error reading credential: %v", err)
+		}
+		user, err := readTrimmed(filepath.Join(dir, prefix+"user"))
+		if errors.Is(err, os.ErrNotExist) {
+			user = DefaultUser
+		} else if err != nil {
+			return "", "", fmt.Errorf("error reading credential: %v", err)
+		}
+		return user, password, nil
+	}
+	return "", "", ErrNotFound
+}
+
+// Keyring looks up the credentials in the Secret Service keyring, e.g. of
+// GNOME or KDE, using secret-tool. Entries have the attributes service
+// go-smafs, host <target> and field user or password. The profile is
+// optional.
+type Keyring struct {
+	// Command is the secret-tool binary, found in PATH if empty
+	Command string
+}
+
+var _ = (Provider)((*Keyring)(nil))
+
+func (k *Keyring) lookup(target, field string) (string, error) {
+	command := k.Command
+	if command == "" {
+		command = "secret-tool"
+	}
+	out, err := exec.Command(command, "lookup", "service", "go-smafs", "host", target, "field", field).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) || errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		// secret-tool exits with 1 if nothing matches, it may not be installed
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("error querying keyring: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (k *Keyring) Credentials(target string) (string, string, error) {
+	password, err := k.lookup(target, "password")
+	if err != nil {
+		return "", "", err
+	}
+	user, err := k.lookup(target, "user")
+	if errors.Is(err, ErrNotFound) {
+		user = DefaultUser
+	} else if err != nil {
+		return "", "", err
+	}
+	return user, password, nil
+}
+
+// Prompt asks for the credentials. An empty profile selects DefaultUser.
+type Prompt struct {
+	In  io.Reader
+	Out io.Writer
+	// Echo turns the echo of the terminal on or off, nil if In is no
+	// terminal
+	Echo func(on bool)
+
+	reader *bufio.Reader
+}
+
+// NewPrompt returns a prompt on the terminal. Its Credentials return
+// ErrNotFound if stdin is no terminal.
+func NewPrompt() *Prompt {
+	return &Prompt{In: os.Stdin, Out: os.Stderr, Echo: sttyEcho}
+}
+
+var _ = (Provider)((*Prompt)(nil))
+
+// sttyEcho switches the echo of the terminal on stdin.
+func sttyEcho(on bool) {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
+}
+
+// isTerminal reports whether r is a character device.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *Prompt) Credentials(target string) (string, string, error) {
+	if _, ok := p.In.(*os.File); ok && !isTerminal(p.In) {
+		return "", "", ErrNotFound
+	}
+	if p.reader == nil {
+		p.reader = bufio.NewReader(p.In)
+	}
+
+	fmt.Fprintf(p.Out, "Profile for %s [%s]: ", target, DefaultUser)
+	user, err := p.reader.ReadString('\n')
+	if err != nil {
+		return "", "", fmt.Errorf("error reading profile: %v", err)
+	}
+	user = strings.TrimSpace(user)
+	if user == "" {
+		user = DefaultUser
+	}
+
+	fmt.Fprintf(p.Out, "Password for %s: ", target)
+	if p.Echo != nil {
+		p.Echo(false)
+		defer p.Echo(true)
+	}
+	password, err := p.reader.ReadString('\n')
+	fmt.Fprintln(p.Out)
+	if err != nil && (err != io.EOF || password == "") {
+		return "", "", fmt.Errorf("error reading password: %v", err)
+	}
+	return user, strings.TrimRight(password, "\r\n"), nil
+}
+
+// Chain tries the providers in order until one has credentials.
+type Chain []Provider
+
+var _ = (Provider)((Chain)(nil))
+
+func (c Chain) Credentials(target string) (string, string, error) {
+	for _, p := range c {
+		user, password, err := p.Credentials(target)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return user, password, err
+	}
+	return "", "", fmt.Errorf("error no credentials for %s: %w", target, ErrNotFound)
+}
+
+// DefaultSpec selects systemd credentials if available, else the files
+// named by the environment.
+const DefaultSpec = "systemd,env"
+
+// Parse returns the chain of the comma separated providers in spec: env,
+// systemd, keyring and prompt.
+func Parse(spec string) (Provider, error) {
+	var chain Chain
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "env":
+			chain = append(chain, Env())
+		case "systemd":
+			chain = append(chain, &Systemd{})
+		case "keyring":
+			chain = append(chain, &Keyring{})
+		case "prompt":
+			chain = append(chain, NewPrompt())
+		default:
+			return nil, fmt.Errorf("error unknown credential provider: %s", name)
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,133 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatalf("error writing %s: %v", name, err)
+	}
+	return p
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	f := &Files{User: writeFile(t, dir, "user", "istl\n"), Password: writeFile(t, dir, "pass", "  secret\r\n")}
+	user, password, err := f.Credentials("sma733147246.lan")
+	if err != nil {
+		t.Fatalf("Credentials returned an error: %v", err)
+	}
+	if user != "istl" || password != "secret" {
+		t.Errorf("Invalid credentials: %q, %q", user, password)
+	}
+
+	if _, _, err := (&Files{}).Credentials("sma733147246.lan"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	f.Password = filepath.Join(dir, "missing")
+	if _, _, err := f.Credentials("sma733147246.lan"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected error for missing file, got %v", err)
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "smafs.pass", "generic\n")
+	writeFile(t, dir, "smafs.sma733147246.lan.user", "istl\n")
+	writeFile(t, dir, "smafs.sma733147246.lan.pass", "specific\n")
+	s := &Systemd{Dir: dir}
+
+	user, password, err := s.Credentials("sma733147246.lan")
+	if err != nil || user != "istl" || password != "specific" {
+		t.Errorf("Invalid credentials: %q, %q, %v", user, password, err)
+	}
+	user, password, err = s.Credentials("192.168.178.22")
+	if err != nil || user != DefaultUser || password != "generic" {
+		t.Errorf("Invalid credentials: %q, %q, %v", user, password, err)
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, _, err := (&Systemd{}).Credentials("192.168.178.22"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	// fake secret-tool knowing the password of a single host
+	dir := t.TempDir()
+	script := `#!/bin/sh
+if [ "$5" = "sma733147246.lan" ] && [ "$7" = "password" ]; then
+	echo "secret"
+	exit 0
+fi
+exit 1
+`
+	command := writeFile(t, dir, "secret-tool", script)
+	if err := os.Chmod(command, 0700); err != nil {
+		t.Fatal(err)
+	}
+	k := &Keyring{Command: command}
+
+	user, password, err := k.Credentials("sma733147246.lan")
+	if err != nil || user != DefaultUser || password != "secret" {
+		t.Errorf("Invalid credentials: %q, %q, %v", user, password, err)
+	}
+	if _, _, err := k.Credentials("192.168.178.22"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	k.Command = filepath.Join(dir, "missing")
+	if _, _, err := k.Credentials("sma733147246.lan"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without secret-tool, got %v", err)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	var out strings.Builder
+	echo := []bool{}
+	p := &Prompt{In: strings.NewReader("\nsecret with spaces \n"), Out: &out, Echo: func(on bool) { echo = append(echo, on) }}
+	user, password, err := p.Credentials("sma733147246.lan")
+	if err != nil || user != DefaultUser || password != "secret with spaces " {
+		t.Errorf("Invalid credentials: %q, %q, %v", user, password, err)
+	}
+	if !strings.Contains(out.String(), "Password for sma733147246.lan: ") {
+		t.Errorf("Invalid prompt: %q", out.String())
+	}
+	if len(echo) != 2 || echo[0] || !echo[1] {
+		t.Errorf("Expected echo to be switched off and on, got %v", echo)
+	}
+}
+
+func TestChain(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	t.Setenv("SMAFS_USER", writeFile(t, dir, "user", "usr\n"))
+	t.Setenv("SMAFS_PASS", writeFile(t, dir, "pass", "secret\n"))
+
+	p, err := Parse(DefaultSpec)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	user, password, err := p.Credentials("sma733147246.lan")
+	if err != nil || user != "usr" || password != "secret" {
+		t.Errorf("Invalid credentials: %q, %q, %v", user, password, err)
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	writeFile(t, dir, "smafs.pass", "from systemd")
+	if _, password, _ := p.Credentials("sma733147246.lan"); password != "from systemd" {
+		t.Errorf("Expected systemd credentials first, got %q", password)
+	}
+
+	if _, _, err := (Chain{&Files{}}).Credentials("sma733147246.lan"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := Parse("env,vault"); err == nil {
+		t.Errorf("Expected error for unknown provider")
+	}
+}
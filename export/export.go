@@ -0,0 +1,218 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/iofs"
+	"github.com/dominikbayerl/go-smafs/parser"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Writer encodes samples to w.
+type Writer func(w io.Writer, samples []types.Sample) error
+
+// Formats maps the supported output format names to their writers.
+var Formats = map[string]Writer{
+	"csv":     WriteCSV,
+	"json":    WriteJSON,
+	"influx":  WriteInflux,
+	"parquet": WriteParquet,
+}
+
+// FileFormats maps the extensions of virtual files with samples, like those
+// of views and live values, to their writers.
+var FileFormats = map[string]Writer{
+	".csv":  WriteCSV,
+	".json": WriteJSON,
+}
+
+// Source returns the samples logged between from and to.
+type Source func(ctx context.Context, from, to time.Time) ([]types.Sample, error)
+
+// Logger is the source of logged values, implemented by sma.SMAApi.
+type Logger interface {
+	GetLogger(ctx context.Context, key int, from, to time.Time) ([]types.Sample, error)
+}
+
+// LoggerSource returns the values of key from the getLogger endpoint.
+func LoggerSource(logger Logger, key int) Source {
+	return func(ctx context.Context, from, to time.Time) ([]types.Sample, error) {
+		return logger.GetLogger(ctx, key, from, to)
+	}
+}
+
+// FilesSource returns the values of all CSV files, plain or compressed,
+// below dir. Timestamps in the files are interpreted in loc.
+func FilesSource(b backend.Backend, dir string, loc *time.Location) Source {
+	return func(ctx context.Context, from, to time.Time) ([]types.Sample, error) {
+		fsys := iofs.New(ctx, b)
+		root := strings.Trim(dir, "/")
+		if root == "" {
+			root = "."
+		}
+
+		var samples []types.Sample
+		err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			lower := strings.ToLower(p)
+			if !strings.HasSuffix(lower, ".csv") && !strings.HasSuffix(lower, ".csv.gz") && !strings.HasSuffix(lower, ".zip") {
+				return nil
+			}
+			content, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			files, err := parser.ParseFile(p, content, loc)
+			if err != nil {
+				return fmt.Errorf("error parsing %s: %v", p, err)
+			}
+			for _, f := range files {
+				samples = append(samples, f.Samples()...)
+			}
+			return nil
+		})
+		return samples, err
+	}
+}
+
+// Options configure an export.
+type Options struct {
+	// From and To limit the exported samples to [From, To)
+	From, To time.Time
+	// Watermark is the path of a file storing the time of the newest
+	// exported sample. If set, only newer samples are exported.
+	Watermark string
+}
+
+// Export writes the samples of src selected by opts to out and returns the
+// number of samples written. Samples are sorted by time and converted to UTC.
+func Export(ctx context.Context, out io.Writer, write Writer, src Source, opts Options) (int, error) {
+	from := opts.From
+	var watermark time.Time
+	if opts.Watermark != "" {
+		var err error
+		watermark, err = LoadWatermark(opts.Watermark)
+		if err != nil {
+			return 0, err
+		}
+		if watermark.After(from) {
+			from = watermark
+		}
+	}
+
+	all, err := src(ctx, from, opts.To)
+	if err != nil {
+		return 0, err
+	}
+	samples := make([]types.Sample, 0, len(all))
+	for _, s := range all {
+		if s.Time.Before(from) || !s.Time.Before(opts.To) || !s.Time.After(watermark) {
+			continue
+		}
+		s.Time = s.Time.UTC()
+		samples = append(samples, s)
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+	if err := write(out, samples); err != nil {
+		return 0, err
+	}
+	if opts.Watermark != "" && len(samples) > 0 {
+		if err := SaveWatermark(opts.Watermark, samples[len(samples)-1].Time); err != nil {
+			return 0, err
+		}
+	}
+	return len(samples), nil
+}
+
+// LoadWatermark reads the watermark stored at name. A missing file yields
+// the zero time.
+func LoadWatermark(name string) (time.Time, error) {
+	content, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("error reading watermark: %v", err)
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing watermark: %v", err)
+	}
+	return t, nil
+}
+
+// SaveWatermark stores t at name. The file is replaced atomically.
+func SaveWatermark(name string, t time.Time) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(t.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing watermark: %v", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("error writing watermark: %v", err)
+	}
+	return nil
+}
+
+// WriteCSV writes samples as comma separated values with a header line.
+func WriteCSV(w io.Writer, samples []types.Sample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "device", "channel", "unit", "value"})
+	for _, s := range samples {
+		cw.Write([]string{s.Time.UTC().Format(time.RFC3339), s.Device, s.Channel, s.Unit, strconv.FormatFloat(s.Value, 'f', -1, 64)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+	return nil
+}
+
+// WriteJSON writes samples as JSON array.
+func WriteJSON(w io.Writer, samples []types.Sample) error {
+	if samples == nil {
+		samples = []types.Sample{}
+	}
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		return fmt.Errorf("error writing JSON: %v", err)
+	}
+	return nil
+}
+
+// influxEscaper escapes tag keys and values of the line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// WriteInflux writes samples in InfluxDB line protocol to the measurement
+// "sma", tagged with device, channel and unit.
+func WriteInflux(w io.Writer, samples []types.Sample) error {
+	for _, s := range samples {
+		line := fmt.Sprintf("sma,device=%s,channel=%s,unit=%s value=%s %d\n",
+			influxTag(s.Device), influxTag(s.Channel), influxTag(s.Unit),
+			strconv.FormatFloat(s.Value, 'f', -1, 64), s.Time.UnixNano())
+		if _, err := io.WriteString(w, line); err != nil {
+			return fmt.Errorf("error writing line protocol: %v", err)
+		}
+	}
+	return nil
+}
+
+// influxTag escapes a tag value, empty values are not allowed.
+func influxTag(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return influxEscaper.Replace(value)
+}
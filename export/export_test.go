@@ -0,0 +1,199 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+var testSamples = []types.Sample{
+	{Device: "2130012345", Channel: "TotWhOut", Unit: "Wh", Time: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), Value: 1000},
+	{Device: "2130012345", Channel: "TotWhOut", Unit: "Wh", Time: time.Date(2026, 10, 17, 12, 5, 0, 0, time.UTC), Value: 1042.5},
+	{Device: "SB 5000,TL", Channel: "Pac", Unit: "kW", Time: time.Date(2026, 10, 17, 12, 5, 0, 0, time.UTC), Value: 3.21},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, testSamples); err != nil {
+		t.Fatalf("WriteCSV returned an error: %v", err)
+	}
+	expected := "time,device,channel,unit,value\n" +
+		"2026-10-17T12:00:00Z,2130012345,TotWhOut,Wh,1000\n" +
+		"2026-10-17T12:05:00Z,2130012345,TotWhOut,Wh,1042.5\n" +
+		"2026-10-17T12:05:00Z,\"SB 5000,TL\",Pac,kW,3.21\n"
+	if buf.String() != expected {
+		t.Errorf("Invalid CSV:\n%s", buf.String())
+	}
+}
+
+func TestWriteInflux(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteInflux(&buf, testSamples); err != nil {
+		t.Fatalf("WriteInflux returned an error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %d", len(lines))
+	}
+	if lines[0] != "sma,device=2130012345,channel=TotWhOut,unit=Wh value=1000 1792238400000000000" {
+		t.Errorf("Invalid line: %s", lines[0])
+	}
+	if lines[2] != `sma,device=SB\ 5000\,TL,channel=Pac,unit=kW value=3.21 1792238700000000000` {
+		t.Errorf("Invalid line: %s", lines[2])
+	}
+}
+
+// thriftReader decodes the Thrift compact protocol into maps of field ids
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5, 6:
+		v, _ := binary.ReadVarint(t.r)
+		return v
+	case 8:
+		n, _ := binary.ReadUvarint(t.r)
+		b := make([]byte, n)
+		t.r.Read(b)
+		return string(b)
+	case 9:
+		header, _ := t.r.ReadByte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size, _ = binary.ReadUvarint(t.r)
+		}
+		list := make([]interface{}, size)
+		for idx := range list {
+			list[idx] = t.value(header & 0x0f)
+		}
+		return list
+	case 12:
+		return t.structure()
+	}
+	panic(fmt.Sprintf("unsupported type %d", typ))
+}
+
+func (t *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var id int16
+	for {
+		header, _ := t.r.ReadByte()
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, _ := binary.ReadVarint(t.r)
+			id = int16(v)
+		}
+		fields[id] = t.value(header & 0x0f)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, testSamples); err != nil {
+		t.Fatalf("WriteParquet returned an error: %v", err)
+	}
+	file := buf.Bytes()
+	if string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatal("Missing parquet magic")
+	}
+
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := file[len(file)-8-footerLen : len(file)-8]
+	meta := (&thriftReader{bytes.NewReader(footer)}).structure()
+	if meta[1] != int64(1) || meta[3] != int64(3) {
+		t.Errorf("Invalid file meta data: %v", meta)
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != 6 || schema[0].(map[int16]interface{})[5] != int64(5) || schema[1].(map[int16]interface{})[4] != "time" {
+		t.Errorf("Invalid schema: %v", schema)
+	}
+
+	columns := meta[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+	if len(columns) != 5 {
+		t.Fatalf("Expected 5 column chunks, got %d", len(columns))
+	}
+
+	// time column
+	chunk := columns[0].(map[int16]interface{})[3].(map[int16]interface{})
+	r := bytes.NewReader(file[chunk[9].(int64):])
+	header := (&thriftReader{r}).structure()
+	if header[5].(map[int16]interface{})[1] != int64(3) {
+		t.Errorf("Invalid page header: %v", header)
+	}
+	var millis [3]int64
+	binary.Read(r, binary.LittleEndian, &millis)
+	if millis[0] != testSamples[0].Time.UnixMilli() || millis[2] != testSamples[2].Time.UnixMilli() {
+		t.Errorf("Invalid time values: %v", millis)
+	}
+
+	// value column
+	chunk = columns[4].(map[int16]interface{})[3].(map[int16]interface{})
+	r = bytes.NewReader(file[chunk[9].(int64):])
+	(&thriftReader{r}).structure()
+	var bits [3]uint64
+	binary.Read(r, binary.LittleEndian, &bits)
+	if math.Float64frombits(bits[1]) != 1042.5 {
+		t.Errorf("Invalid double value: %v", math.Float64frombits(bits[1]))
+	}
+}
+
+func TestExportWatermark(t *testing.T) {
+	watermark := filepath.Join(t.TempDir(), "watermark")
+	src := func(ctx context.Context, from, to time.Time) ([]types.Sample, error) {
+		return testSamples, nil
+	}
+	opts := Options{From: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), Watermark: watermark}
+
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), &buf, WriteCSV, src, opts)
+	if err != nil || n != 3 {
+		t.Fatalf("Export returned %d, %v", n, err)
+	}
+	content, _ := os.ReadFile(watermark)
+	if string(content) != "2026-10-17T12:05:00Z\n" {
+		t.Errorf("Invalid watermark: %q", content)
+	}
+
+	n, err = Export(context.Background(), &buf, WriteCSV, src, opts)
+	if err != nil || n != 0 {
+		t.Errorf("Expected no samples after watermark, got %d, %v", n, err)
+	}
+}
+
+func TestFilesSource(t *testing.T) {
+	csv := ";Pac\nyyyy-MM-dd HH:mm;W\n2026-10-17 12:00;1234.5\n2026-10-18 12:00;12,5\n"
+	m := fstest.MapFS{
+		"DATA/2026/Mean.csv": &fstest.MapFile{Data: []byte(csv)},
+		"DATA/readme.txt":    &fstest.MapFile{Data: []byte("not a CSV")},
+	}
+	var buf bytes.Buffer
+	opts := Options{From: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}
+	n, err := Export(context.Background(), &buf, WriteCSV, FilesSource(backend.NewMemory(m), "/DATA", time.UTC), opts)
+	if err != nil || n != 1 {
+		t.Fatalf("Export returned %d, %v", n, err)
+	}
+	if !strings.Contains(buf.String(), "2026-10-17T12:00:00Z,,Pac,W,1234.5") {
+		t.Errorf("Invalid export: %s", buf.String())
+	}
+}
@@ -0,0 +1,255 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Parquet constants, see parquet.thrift of the format specification
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage = 0
+
+	parquetUncompressed = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn is a required column of the written schema.
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	// value returns the PLAIN encoding of the column value of s
+	value func(buf *bytes.Buffer, s types.Sample)
+}
+
+func plainByteArray(buf *bytes.Buffer, v string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+	buf.WriteString(v)
+}
+
+var parquetColumns = []parquetColumn{
+	{name: "time", physicalType: parquetInt64, convertedType: parquetTimestampMillis, value: func(buf *bytes.Buffer, s types.Sample) {
+		binary.Write(buf, binary.LittleEndian, s.Time.UnixNano()/int64(1e6))
+	}},
+	{name: "device", physicalType: parquetByteArray, convertedType: parquetUTF8, value: func(buf *bytes.Buffer, s types.Sample) {
+		plainByteArray(buf, s.Device)
+	}},
+	{name: "channel", physicalType: parquetByteArray, convertedType: parquetUTF8, value: func(buf *bytes.Buffer, s types.Sample) {
+		plainByteArray(buf, s.Channel)
+	}},
+	{name: "unit", physicalType: parquetByteArray, convertedType: parquetUTF8, value: func(buf *bytes.Buffer, s types.Sample) {
+		plainByteArray(buf, s.Unit)
+	}},
+	{name: "value", physicalType: parquetDouble, convertedType: -1, value: func(buf *bytes.Buffer, s types.Sample) {
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(s.Value))
+	}},
+}
+
+// WriteParquet writes samples as uncompressed Parquet file with a single row
+// group and the columns time (UTC milliseconds), device, channel, unit and
+// value.
+func WriteParquet(w io.Writer, samples []types.Sample) error {
+	var file bytes.Buffer
+	file.Write(parquetMagic)
+
+	// Each column chunk consists of a single data page. All columns are
+	// required, so pages hold no repetition or definition levels.
+	chunks := make([]*thriftStruct, len(parquetColumns))
+	var totalSize int64
+	for idx, col := range parquetColumns {
+		var page bytes.Buffer
+		for _, s := range samples {
+			col.value(&page, s)
+		}
+
+		header := newThriftStruct().
+			i32(1, parquetDataPage).
+			i32(2, int32(page.Len())).
+			i32(3, int32(page.Len())).
+			structure(5, newThriftStruct().
+				i32(1, int32(len(samples))).
+				i32(2, parquetPlain).
+				i32(3, parquetRLE).
+				i32(4, parquetRLE))
+
+		offset := int64(file.Len())
+		file.Write(header.bytes())
+		file.Write(page.Bytes())
+		size := int64(file.Len()) - offset
+		totalSize += size
+
+		meta := newThriftStruct().
+			i32(1, col.physicalType).
+			i32List(2, []int32{parquetPlain, parquetRLE}).
+			stringList(3, []string{col.name}).
+			i32(4, parquetUncompressed).
+			i64(5, int64(len(samples))).
+			i64(6, size).
+			i64(7, size).
+			i64(9, offset)
+		chunks[idx] = newThriftStruct().
+			i64(2, offset).
+			structure(3, meta)
+	}
+
+	schema := []*thriftStruct{newThriftStruct().
+		string(4, "schema").
+		i32(5, int32(len(parquetColumns)))}
+	for _, col := range parquetColumns {
+		element := newThriftStruct().
+			i32(1, col.physicalType).
+			i32(3, parquetRequired).
+			string(4, col.name)
+		if col.convertedType >= 0 {
+			element.i32(6, col.convertedType)
+		}
+		schema = append(schema, element)
+	}
+
+	rowGroup := newThriftStruct().
+		structList(1, chunks).
+		i64(2, totalSize).
+		i64(3, int64(len(samples)))
+	footer := newThriftStruct().
+		i32(1, 1).
+		structList(2, schema).
+		i64(3, int64(len(samples))).
+		structList(4, []*thriftStruct{rowGroup}).
+		string(6, "go-smafs").
+		bytes()
+
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.Write(parquetMagic)
+
+	if _, err := file.WriteTo(w); err != nil {
+		return fmt.Errorf("error writing parquet: %v", err)
+	}
+	return nil
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftStructType = 12
+)
+
+// thriftStruct encodes a struct in the Thrift compact protocol. Fields must
+// be added in ascending order.
+type thriftStruct struct {
+	buf     bytes.Buffer
+	lastID  int16
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newThriftStruct() *thriftStruct {
+	return &thriftStruct{}
+}
+
+func (t *thriftStruct) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftStruct) varint(v int64) {
+	n := binary.PutVarint(t.scratch[:], v)
+	t.buf.Write(t.scratch[:n])
+}
+
+func (t *thriftStruct) uvarint(v uint64) {
+	n := binary.PutUvarint(t.scratch[:], v)
+	t.buf.Write(t.scratch[:n])
+}
+
+func (t *thriftStruct) listHeader(size int, elemType byte) {
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftStruct) i32(id int16, v int32) *thriftStruct {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+	return t
+}
+
+func (t *thriftStruct) i64(id int16, v int64) *thriftStruct {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+	return t
+}
+
+func (t *thriftStruct) string(id int16, v string) *thriftStruct {
+	t.fieldHeader(id, thriftBinary)
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+	return t
+}
+
+func (t *thriftStruct) structure(id int16, v *thriftStruct) *thriftStruct {
+	t.fieldHeader(id, thriftStructType)
+	t.buf.Write(v.bytes())
+	return t
+}
+
+func (t *thriftStruct) i32List(id int16, v []int32) *thriftStruct {
+	t.fieldHeader(id, thriftList)
+	t.listHeader(len(v), thriftI32)
+	for _, e := range v {
+		t.varint(int64(e))
+	}
+	return t
+}
+
+func (t *thriftStruct) stringList(id int16, v []string) *thriftStruct {
+	t.fieldHeader(id, thriftList)
+	t.listHeader(len(v), thriftBinary)
+	for _, e := range v {
+		t.uvarint(uint64(len(e)))
+		t.buf.WriteString(e)
+	}
+	return t
+}
+
+func (t *thriftStruct) structList(id int16, v []*thriftStruct) *thriftStruct {
+	t.fieldHeader(id, thriftList)
+	t.listHeader(len(v), thriftStructType)
+	for _, e := range v {
+		t.buf.Write(e.bytes())
+	}
+	return t
+}
+
+// bytes returns the encoded struct including the stop field.
+func (t *thriftStruct) bytes() []byte {
+	return append(t.buf.Bytes()[:t.buf.Len():t.buf.Len()], 0)
+}
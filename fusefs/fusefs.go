@@ -2,20 +2,44 @@ package fusefs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash/fnv"
+	"io"
+	iofs "io/fs"
+	"log/slog"
+	"net"
 	"path"
-	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/dominikbayerl/go-smafs/backend"
 	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
 type FuseRoot struct {
 	ctx     context.Context
-	api     *sma.SMAApi
+	backend backend.Backend
 	counter uint
+
+	// inode numbers handed out, by key and by number
+	mu    sync.Mutex
+	inos  map[string]uint64
+	paths map[uint64]string
+	// checksums of downloaded files by key, valid for their timestamp
+	checksums map[string]checksum
+}
+
+// checksum is the hex encoded SHA-256 of a file as of its timestamp.
+type checksum struct {
+	timestamp uint64
+	sum       string
 }
 
 type FuseNode struct {
@@ -23,62 +47,202 @@ type FuseNode struct {
 	root    *FuseRoot
 	Size    uint64
 	content []byte
+
+	// entry is updated when Watch detects changes
+	mu    sync.Mutex
+	entry types.FSEntry
+	// fetched is the time the content was last downloaded
+	fetched time.Time
 }
 
-func NewFuseFS(ctx context.Context, api *sma.SMAApi) *FuseNode {
-	return &FuseNode{root: &FuseRoot{ctx: ctx, api: api, counter: 0}}
+func NewFuseFS(ctx context.Context, b backend.Backend) *FuseNode {
+	return &FuseNode{root: &FuseRoot{ctx: ctx, backend: b, counter: 0}}
 
 }
 
 var _ = (fs.NodeGetattrer)((*FuseNode)(nil))
 
 func (r *FuseNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
-	out.Mode = 0755
+	defer logOp(ctx, "getattr", path.Join("/", r.Path(nil)), time.Now(), 0, nil)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	setAttr(&out.Attr, r.entry)
 	return 0
 }
 
+// toErrno maps errors of the backend to the errno reported to the kernel.
+func toErrno(err error) syscall.Errno {
+	var apiErr *sma.APIError
+	var statusErr *sma.StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, iofs.ErrNotExist), errors.Is(err, sma.ErrInvalidPath),
+		errors.As(err, &statusErr) && statusErr.Code == 404:
+		return syscall.ENOENT
+	case errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+	case errors.As(err, &apiErr) && apiErr.Code == 401:
+		return syscall.EACCES
+	case errors.As(err, &apiErr) && apiErr.Code == 503:
+		return syscall.EAGAIN
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return syscall.ETIMEDOUT
+	}
+	return syscall.EIO
+}
+
+// logOp logs the outcome of the FUSE operation op on p. Failures other than
+// missing entries and attributes are warnings, the rest is debug output.
+func logOp(ctx context.Context, op, p string, start time.Time, errno syscall.Errno, err error) {
+	level := slog.LevelDebug
+	if errno != 0 && errno != syscall.ENOENT && errno != syscall.ENODATA {
+		level = slog.LevelWarn
+	}
+	attrs := []any{"op", op, "path", p, "errno", int(errno), "duration", time.Since(start)}
+	if errno != 0 {
+		attrs = append(attrs, "errname", errno.Error())
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Log(ctx, level, "fuse", attrs...)
+}
+
+// setAttr fills the attributes known from the listing of a remote entry.
+func setAttr(out *fuse.Attr, entry types.FSEntry) {
+	out.Mode = 0755
+	out.Size = entry.Size
+	if entry.Timestamp != 0 {
+		mtime := time.Unix(int64(entry.Timestamp), 0)
+		out.SetTimes(nil, &mtime, &mtime)
+	}
+}
+
 var _ = (fs.NodeReaddirer)((*FuseNode)(nil))
 var _ = (fs.NodeLookuper)((*FuseNode)(nil))
 
+// ReserveIno returns an inode number that is not derived from a path.
 func (r *FuseRoot) ReserveIno() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reserveIno()
+}
+
+func (r *FuseRoot) reserveIno() uint64 {
+	// inode 1 belongs to the root node
 	r.counter++
+	for r.counter <= 1 {
+		r.counter++
+	}
 	return uint64(r.counter)
 }
 
-func (r *FuseRoot) MakeIno(name string) uint64 {
+// MakeIno returns the inode number of the entry at the absolute path name on
+// device. The number is a hash of both, so it is stable across remounts. On
+// hash collisions the later path is hashed again with an increasing salt until
+// a free number is found, so it is stable as long as the paths are looked up
+// in the same order.
+func (r *FuseRoot) MakeIno(device, name string) uint64 {
+	key := device + "\x00" + name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inos == nil {
+		r.inos = make(map[string]uint64)
+		r.paths = make(map[uint64]string)
+	}
+	if ino, ok := r.inos[key]; ok {
+		return ino
+	}
+
+	ino := hashIno(key, 0)
+	for salt := 1; ; salt++ {
+		// inode 1 belongs to the root node
+		if _, taken := r.paths[ino]; !taken && ino > 1 {
+			break
+		}
+		ino = hashIno(key, salt)
+	}
+	r.inos[key] = ino
+	r.paths[ino] = key
+	return ino
+}
+
+// hashIno hashes key, followed by salt unless it is 0.
+func hashIno(key string, salt int) uint64 {
 	h := fnv.New64a()
-	h.Write([]byte(name))
+	h.Write([]byte(key))
+	if salt != 0 {
+		fmt.Fprintf(h, "\x00%d", salt)
+	}
 	return h.Sum64()
 }
 
-func (r *FuseNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+// StoreChecksum caches the checksum sum of the file at the absolute path name
+// on device, as of timestamp.
+func (r *FuseRoot) StoreChecksum(device, name string, timestamp uint64, sum string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.checksums == nil {
+		r.checksums = make(map[string]checksum)
+	}
+	r.checksums[device+"\x00"+name] = checksum{timestamp: timestamp, sum: sum}
+}
+
+// Checksum returns the cached checksum of the file at the absolute path name
+// on device, or "" if unknown or the file changed since.
+func (r *FuseRoot) Checksum(device, name string, timestamp uint64) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.checksums[device+"\x00"+name]; ok && c.timestamp == timestamp {
+		return c.sum
+	}
+	return ""
+}
+
+func (r *FuseNode) Readdir(ctx context.Context) (_ fs.DirStream, errno syscall.Errno) {
 	parentDir := path.Join("/", r.Path(nil))
+	start := time.Now()
+	var err error
+	defer func() { logOp(ctx, "readdir", parentDir, start, errno, err) }()
 
-	entries, err := r.root.api.GetFS(r.root.ctx, parentDir)
+	entries, err := r.root.backend.List(r.root.ctx, parentDir)
 	if err != nil {
-		return nil, syscall.EFAULT
-	}
-	v := make([]fuse.DirEntry, len(entries))
-	for idx, entry := range entries {
-		if entry.Filename != "" {
-			v[idx] = fuse.DirEntry{Mode: fuse.S_IFREG, Name: entry.Filename, Ino: r.root.MakeIno(entry.Filename)}
-		} else if entry.DirectoryName != "" {
-			v[idx] = fuse.DirEntry{Mode: fuse.S_IFDIR, Name: entry.DirectoryName, Ino: r.root.MakeIno(entry.DirectoryName)}
+		return nil, toErrno(err)
+	}
+	v := make([]fuse.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		// names like ".." or with slashes would escape the directory
+		if entry.Filename != "" && sma.ValidName(entry.Filename) {
+			v = append(v, fuse.DirEntry{Mode: fuse.S_IFREG, Name: entry.Filename, Ino: r.root.MakeIno(entry.Device, path.Join(parentDir, entry.Filename))})
+		} else if entry.DirectoryName != "" && sma.ValidName(entry.DirectoryName) {
+			v = append(v, fuse.DirEntry{Mode: fuse.S_IFDIR, Name: entry.DirectoryName, Ino: r.root.MakeIno(entry.Device, path.Join(parentDir, entry.DirectoryName))})
 		}
 	}
 	return fs.NewListDirStream(v), 0
 }
 
-func (r *FuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	mode := syscall.S_IFDIR
-	// TODO: This is a heuristic and should be fixed.
-	// The mode should be passed somehow from the Readdir()-enumeration
-	if filepath.Ext(name) != "" {
-		mode = syscall.S_IFREG
+func (r *FuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (_ *fs.Inode, errno syscall.Errno) {
+	p := path.Join("/", r.Path(nil), name)
+	start := time.Now()
+	var err error
+	defer func() { logOp(ctx, "lookup", p, start, errno, err) }()
+
+	if !sma.ValidName(name) {
+		return nil, syscall.ENOENT
 	}
-	out.Mode = 0755
-	out.Size = 0
-	return r.NewInode(ctx, &FuseNode{root: r.root}, fs.StableAttr{Mode: uint32(mode), Ino: r.root.MakeIno(name)}), 0
+	entry, err := r.root.backend.Stat(r.root.ctx, p)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	mode := syscall.S_IFREG
+	if entry.DirectoryName != "" {
+		mode = syscall.S_IFDIR
+	}
+	setAttr(&out.Attr, entry)
+	node := &FuseNode{root: r.root, Size: entry.Size, entry: entry}
+	return r.NewInode(ctx, node, fs.StableAttr{Mode: uint32(mode), Ino: r.root.MakeIno(entry.Device, p)}), 0
 }
 
 type bytesFileHandle struct {
@@ -89,6 +253,10 @@ type bytesFileHandle struct {
 var _ = (fs.FileReader)((*bytesFileHandle)(nil))
 
 func (fh *bytesFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	// reads past the end return no data
+	if off > int64(len(fh.content)) {
+		off = int64(len(fh.content))
+	}
 	end := off + int64(len(dest))
 	if end > int64(len(fh.content)) {
 		end = int64(len(fh.content))
@@ -103,15 +271,32 @@ func (fh *bytesFileHandle) Read(ctx context.Context, dest []byte, off int64) (fu
 var _ = (fs.NodeOpener)((*FuseNode)(nil))
 
 func (r *FuseNode) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	p := path.Join("/", r.Path(nil))
+	start := time.Now()
+	var err error
+	defer func() { logOp(ctx, "open", p, start, errno, err) }()
+
 	// disallow writes
-	if fuseFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
+	if openFlags&(syscall.O_RDWR|syscall.O_WRONLY) != 0 {
 		return nil, 0, syscall.EROFS
 	}
 
-	content, err := r.root.api.Download(r.root.ctx, r.Path(nil))
+	rc, err := r.root.backend.Open(r.root.ctx, p)
 	if err != nil {
-		return nil, 0, syscall.EFAULT
+		return nil, 0, toErrno(err)
 	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	sum := sha256.Sum256(content)
+	r.mu.Lock()
+	r.fetched = time.Now()
+	entry := r.entry
+	r.mu.Unlock()
+	r.root.StoreChecksum(entry.Device, p, entry.Timestamp, hex.EncodeToString(sum[:]))
+
 	fh = &bytesFileHandle{
 		content: content,
 	}
@@ -1,19 +1,60 @@
 package fusefs
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	iofs "io/fs"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"testing/fstest"
+	"time"
 
+	"github.com/dominikbayerl/go-smafs/backend"
 	"github.com/dominikbayerl/go-smafs/sma"
 	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
 	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
+// requireFUSE skips the test on hosts without FUSE.
+func requireFUSE(t *testing.T) {
+	t.Helper()
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("FUSE unavailable: %v", err)
+	}
+	if _, err := exec.LookPath("fusermount3"); err != nil {
+		if _, err := exec.LookPath("fusermount"); err != nil {
+			t.Skipf("FUSE unavailable: %v", err)
+		}
+	}
+}
+
+// mount mounts root at dir and waits until it is ready.
+func mount(t *testing.T, dir string, root fs.InodeEmbedder, opts *fs.Options) *fuse.Server {
+	t.Helper()
+	requireFUSE(t)
+	server, err := fs.Mount(dir, root, opts)
+	if err != nil {
+		t.Fatalf("error during fs mount: %v", err)
+	}
+	server.WaitMount()
+	return server
+}
+
 // Define a mock HTTP server and SMAApi for testing
 func setupTest(responseJSON string) (*httptest.Server, *sma.SMAApi) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -34,18 +75,20 @@ func TestMount(t *testing.T) {
 
 	dir, err := os.MkdirTemp("", "fusefs-test")
 	if err != nil {
-		t.Errorf("error creating temporary directory: %v", err)
+		t.Fatalf("error creating temporary directory: %v", err)
 	}
 	defer os.RemoveAll(dir)
 
-	server, err := fs.Mount(dir, &root, opts)
-	if err != nil {
-		t.Errorf("error during fs mount: %v", err)
-	}
+	server := mount(t, dir, &root, opts)
 	defer server.Unmount()
 
-	server.WaitMount()
-	// do some things.
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("error during stat: %v", err)
+	}
+	if !info.IsDir() {
+		t.Errorf("Expected the mount point to be a directory, got %v", info.Mode())
+	}
 }
 
 func TestReaddir(t *testing.T) {
@@ -57,28 +100,530 @@ func TestReaddir(t *testing.T) {
 	mock := tests.NewMockServer(m)
 	defer mock.Close()
 
-	root := FuseNode{root: &FuseRoot{api: &sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}, ctx: context.WithValue(context.Background(), "sid", "test-sid")}}
+	root := FuseNode{root: &FuseRoot{backend: backend.NewHTTP(&sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}), ctx: context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")}}
 	opts := &fs.Options{}
 	opts.Debug = true
 
 	dir, err := os.MkdirTemp("", "fusefs-test")
 	if err != nil {
-		t.Errorf("error creating temporary directory: %v", err)
+		t.Fatalf("error creating temporary directory: %v", err)
 	}
 	defer os.RemoveAll(dir)
 
-	server, err := fs.Mount(dir, &root, opts)
+	server := mount(t, dir, &root, opts)
+	defer server.Unmount()
+
+	entries, err := os.ReadDir(dir + "/DIAGNOSE/")
 	if err != nil {
-		t.Errorf("error during fs mount: %v", err)
+		t.Fatalf("error during readdir: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "file1.txt" || entries[1].Name() != "file2.txt" {
+		t.Errorf("Invalid entries: %v", entries)
 	}
+	if !entries[0].Type().IsRegular() {
+		t.Errorf("Expected a regular file, got %v", entries[0].Type())
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	mtime := time.Unix(1684094403, 0)
+	m := fstest.MapFS{
+		"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: mtime},
+		"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n"), ModTime: mtime},
+	}
+	root := NewFuseFS(context.Background(), backend.NewMemory(m))
+
+	dir, err := os.MkdirTemp("", "fusefs-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := mount(t, dir, root, &fs.Options{})
 	defer server.Unmount()
 
-	server.WaitMount()
+	// names without extension must not be mistaken for directories
+	info, err := os.Stat(dir + "/SYSLOG/blarg")
+	if err != nil {
+		t.Fatalf("error during stat: %v", err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != 14 || !info.ModTime().Equal(mtime) {
+		t.Errorf("Invalid file info: %v %v %v", info.Mode(), info.Size(), info.ModTime())
+	}
 
-	// do some things
-	entries, err := os.ReadDir(dir + "/DIAGNOSE/")
+	content, err := os.ReadFile(dir + "/DIAGNOSE/file1.txt")
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if string(content) != "file1.txt content\n" {
+		t.Errorf("Invalid content: %q", content)
+	}
+
+	if _, err := os.Stat(dir + "/DIAGNOSE/missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
+
+func TestOddNames(t *testing.T) {
+	names := []string{"a b.txt", "#1?.txt", "100%.txt", "Störung & Ürsache.log"}
+	m := fstest.MapFS{}
+	for _, name := range names {
+		m["DIAGNOSE/"+name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	mock := tests.NewMockServer(m)
+	defer mock.Close()
+
+	api := &sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}
+	root := NewFuseFS(context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid"), backend.NewHTTP(api))
+
+	dir, err := os.MkdirTemp("", "fusefs-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := mount(t, dir, root, &fs.Options{})
+	defer server.Unmount()
+
+	entries, err := os.ReadDir(dir + "/DIAGNOSE")
+	if err != nil {
+		t.Fatalf("error during readdir: %v", err)
+	}
+	if len(entries) != len(names) {
+		t.Errorf("Expected %d entries, got %v", len(names), entries)
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(dir + "/DIAGNOSE/" + name)
+		if err != nil {
+			t.Errorf("error reading %q: %v", name, err)
+		} else if string(content) != name {
+			t.Errorf("Invalid content of %q: %q", name, content)
+		}
+	}
+}
+
+func TestTraversalNames(t *testing.T) {
+	server, api := setupTest(`{"result":{"device1":{"/":[{"f":"..","s":1},{"d":"../etc"},{"f":"file1.txt","s":1}]}}}`)
+	defer server.Close()
+	root := NewFuseFS(context.Background(), backend.NewHTTP(api))
+
+	dir, err := os.MkdirTemp("", "fusefs-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fsServer := mount(t, dir, root, &fs.Options{})
+	defer fsServer.Unmount()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error during readdir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "file1.txt" {
+		t.Errorf("Expected only file1.txt, got %v", entries)
+	}
+}
+
+func TestMakeIno(t *testing.T) {
+	root := &FuseRoot{}
+
+	a := root.MakeIno("device1", "/DIAGNOSE/file.txt")
+	b := root.MakeIno("device1", "/SYSLOG/file.txt")
+	c := root.MakeIno("device2", "/DIAGNOSE/file.txt")
+	if a == b || a == c || b == c {
+		t.Errorf("Expected distinct inode numbers, got %d, %d, %d", a, b, c)
+	}
+	if root.MakeIno("device1", "/DIAGNOSE/file.txt") != a {
+		t.Error("Expected the same inode number for repeated lookups")
+	}
+
+	// a fresh root, e.g. after remount, must hand out the same numbers
+	if (&FuseRoot{}).MakeIno("device1", "/DIAGNOSE/file.txt") != a {
+		t.Error("Expected inode numbers to be stable across roots")
+	}
+}
+
+func TestMakeInoCollision(t *testing.T) {
+	root := &FuseRoot{}
+	a := root.MakeIno("device1", "/DIAGNOSE/file.txt")
+
+	// pretend another path hashed to the same number first
+	other := &FuseRoot{}
+	other.MakeIno("", "/other")
+	other.paths[a] = "\x00/other"
+	b := other.MakeIno("device1", "/DIAGNOSE/file.txt")
+	if b == a || b <= 1 {
+		t.Errorf("Expected another inode number on collision, got %d", b)
+	}
+	if other.MakeIno("device1", "/DIAGNOSE/file.txt") != b {
+		t.Error("Expected the inode number to be kept")
+	}
+
+	// the same collision after a remount resolves to the same number
+	again := &FuseRoot{}
+	again.MakeIno("", "/other")
+	again.paths[a] = "\x00/other"
+	if again.MakeIno("device1", "/DIAGNOSE/file.txt") != b {
+		t.Error("Expected collisions to be resolved deterministically")
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	previous := map[string]types.FSEntry{
+		"same.txt":    {Filename: "same.txt", Timestamp: 1, Size: 10},
+		"grown.txt":   {Filename: "grown.txt", Timestamp: 1, Size: 10},
+		"touched.txt": {Filename: "touched.txt", Timestamp: 1, Size: 10},
+		"removed.txt": {Filename: "removed.txt", Timestamp: 1, Size: 10},
+		"replaced":    {Filename: "replaced", Timestamp: 1},
+	}
+	current := map[string]types.FSEntry{
+		"same.txt":    {Filename: "same.txt", Timestamp: 1, Size: 10},
+		"grown.txt":   {Filename: "grown.txt", Timestamp: 1, Size: 20},
+		"touched.txt": {Filename: "touched.txt", Timestamp: 2, Size: 10},
+		"new.txt":     {Filename: "new.txt", Timestamp: 2, Size: 10},
+		"replaced":    {DirectoryName: "replaced", Timestamp: 2},
+	}
+
+	created, modified, deleted := diffEntries(previous, current)
+	if fmt.Sprint(created) != "[new.txt replaced]" {
+		t.Errorf("Invalid created entries: %v", created)
+	}
+	if fmt.Sprint(modified) != "[grown.txt touched.txt]" {
+		t.Errorf("Invalid modified entries: %v", modified)
+	}
+	if fmt.Sprint(deleted) != "[removed.txt replaced]" {
+		t.Errorf("Invalid deleted entries: %v", deleted)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	m := fstest.MapFS{
+		"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: time.Unix(1684094403, 0)},
+		"DIAGNOSE/file2.txt": &fstest.MapFile{Data: []byte("file2.txt content\n"), ModTime: time.Unix(1684094403, 0)},
+	}
+	root := NewFuseFS(context.Background(), backend.NewMemory(m))
+
+	dir, err := os.MkdirTemp("", "fusefs-test")
 	if err != nil {
-		t.Errorf("error during readdir: %v", err)
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := mount(t, dir, root, &fs.Options{})
+	defer server.Unmount()
+
+	// load the directory and its files into the kernel
+	if _, err := os.ReadDir(dir + "/DIAGNOSE"); err != nil {
+		t.Fatalf("error during readdir: %v", err)
+	}
+	if _, err := os.Stat(dir + "/DIAGNOSE/file1.txt"); err != nil {
+		t.Fatalf("error during stat: %v", err)
+	}
+
+	var events []Event
+	snapshots := make(map[string]map[string]types.FSEntry)
+	root.poll(snapshots, func(e Event) { events = append(events, e) })
+
+	m["DIAGNOSE/file1.txt"] = &fstest.MapFile{Data: []byte("file1.txt rotated content\n"), ModTime: time.Unix(1694580920, 0)}
+	m["DIAGNOSE/file3.txt"] = &fstest.MapFile{Data: []byte("file3.txt content\n"), ModTime: time.Unix(1694580920, 0)}
+	delete(m, "DIAGNOSE/file2.txt")
+	root.poll(snapshots, func(e Event) { events = append(events, e) })
+
+	expected := []Event{
+		{Op: EventDelete, Path: "/DIAGNOSE/file2.txt"},
+		{Op: EventCreate, Path: "/DIAGNOSE/file3.txt"},
+		{Op: EventModify, Path: "/DIAGNOSE/file1.txt"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), events)
+	}
+	for idx := range expected {
+		if events[idx].Op != expected[idx].Op || events[idx].Path != expected[idx].Path {
+			t.Errorf("Invalid event %d: %+v", idx, events[idx])
+		}
+	}
+
+	info, err := os.Stat(dir + "/DIAGNOSE/file1.txt")
+	if err != nil {
+		t.Fatalf("error during stat: %v", err)
+	}
+	if info.Size() != 26 {
+		t.Errorf("Expected updated size 26, got %d", info.Size())
+	}
+	if _, err := os.Stat(dir + "/DIAGNOSE/file2.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
+
+// getxattr returns the value of attr or an empty string if it is missing
+func getxattr(t *testing.T, p, attr string) string {
+	dest := make([]byte, 256)
+	n, err := syscall.Getxattr(p, attr, dest)
+	if err == syscall.ENODATA {
+		return ""
+	} else if err != nil {
+		t.Fatalf("error getting %s of %s: %v", attr, p, err)
+	}
+	return string(dest[:n])
+}
+
+func TestXattr(t *testing.T) {
+	m := fstest.MapFS{
+		"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: time.Unix(1684094403, 0)},
+	}
+	root := NewFuseFS(context.Background(), backend.NewMemory(m))
+
+	dir, err := os.MkdirTemp("", "fusefs-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := mount(t, dir, root, &fs.Options{})
+	defer server.Unmount()
+
+	p := dir + "/DIAGNOSE/file1.txt"
+	if v := getxattr(t, p, XattrPath); v != "/DIAGNOSE/file1.txt" {
+		t.Errorf("Invalid %s: %q", XattrPath, v)
+	}
+	if v := getxattr(t, p, XattrTm); v != "1684094403" {
+		t.Errorf("Invalid %s: %q", XattrTm, v)
+	}
+	if v := getxattr(t, p, XattrCached); v != "false" {
+		t.Errorf("Invalid %s: %q", XattrCached, v)
+	}
+	if v := getxattr(t, p, XattrChecksum); v != "" {
+		t.Errorf("Expected no checksum before download, got %q", v)
+	}
+
+	if _, err := os.ReadFile(p); err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if v := getxattr(t, p, XattrCached); v != "true" {
+		t.Errorf("Invalid %s: %q", XattrCached, v)
+	}
+	sum := sha256.Sum256([]byte("file1.txt content\n"))
+	if v := getxattr(t, p, XattrChecksum); v != hex.EncodeToString(sum[:]) {
+		t.Errorf("Invalid %s: %q", XattrChecksum, v)
+	}
+
+	dest := make([]byte, 256)
+	n, err := syscall.Listxattr(p, dest)
+	if err != nil {
+		t.Fatalf("error listing xattrs: %v", err)
+	}
+	names := strings.Split(strings.TrimRight(string(dest[:n]), "\x00"), "\x00")
+	if len(names) != 5 {
+		t.Errorf("Invalid xattr names: %q", names)
+	}
+}
+
+func TestLogOp(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	logOp(context.Background(), "lookup", "/DIAGNOSE/missing.txt", time.Now(), syscall.ENOENT, nil)
+	logOp(context.Background(), "open", "/DIAGNOSE/file1.txt", time.Now(), syscall.EIO, fmt.Errorf("error sending request"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "level=DEBUG") || !strings.Contains(lines[0], "op=lookup") || !strings.Contains(lines[0], "path=/DIAGNOSE/missing.txt") || !strings.Contains(lines[0], "errno=2") {
+		t.Errorf("Invalid log line: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "level=WARN") || !strings.Contains(lines[1], `error="error sending request"`) {
+		t.Errorf("Invalid log line: %s", lines[1])
+	}
+}
+
+func TestToErrno(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected syscall.Errno
+	}{
+		{&iofs.PathError{Op: "stat", Path: "/x", Err: iofs.ErrNotExist}, syscall.ENOENT},
+		{fmt.Errorf("error %q: %w", "..", sma.ErrInvalidPath), syscall.ENOENT},
+		{&sma.APIError{Code: 401}, syscall.EACCES},
+		{&sma.APIError{Code: 503}, syscall.EAGAIN},
+		{fmt.Errorf("error sending request: %w", context.DeadlineExceeded), syscall.ETIMEDOUT},
+		{fmt.Errorf("error unmarshaling response JSON: unexpected end of JSON input"), syscall.EIO},
+		{io.ErrUnexpectedEOF, syscall.EIO},
+		{&sma.StatusError{Code: 404, Status: "404 Not Found"}, syscall.ENOENT},
+		{&backend.SizeMismatchError{Path: "/x", Expected: 10, Actual: 5}, syscall.EIO},
+	} {
+		if actual := toErrno(c.err); actual != c.expected {
+			t.Errorf("toErrno(%v) = %v, expected %v", c.err, actual, c.expected)
+		}
+	}
+}
+
+func TestReadPastEnd(t *testing.T) {
+	fh := &bytesFileHandle{content: []byte("content")}
+	for _, c := range []struct {
+		off  int64
+		size int
+	}{{0, 7}, {4, 3}, {7, 0}, {100, 0}} {
+		res, errno := fh.Read(context.Background(), make([]byte, 16), c.off)
+		if errno != 0 || res.Size() != c.size {
+			t.Errorf("Read at %d returned %d bytes, errno %v, expected %d", c.off, res.Size(), errno, c.size)
+		}
+	}
+}
+
+func TestChecksumCache(t *testing.T) {
+	root := &FuseRoot{}
+	if sum := root.Checksum("1901234567", "/DIAGNOSE/file1.txt", 1684094403); sum != "" {
+		t.Errorf("Expected no checksum before download, got %q", sum)
+	}
+	root.StoreChecksum("1901234567", "/DIAGNOSE/file1.txt", 1684094403, "abc")
+	if sum := root.Checksum("1901234567", "/DIAGNOSE/file1.txt", 1684094403); sum != "abc" {
+		t.Errorf("Expected cached checksum, got %q", sum)
+	}
+	// changed files and other devices are not cached
+	if sum := root.Checksum("1901234567", "/DIAGNOSE/file1.txt", 1684094999); sum != "" {
+		t.Errorf("Expected no checksum for changed file, got %q", sum)
+	}
+	if sum := root.Checksum("1901234568", "/DIAGNOSE/file1.txt", 1684094403); sum != "" {
+		t.Errorf("Expected no checksum for other device, got %q", sum)
+	}
+}
+
+// mountMock mounts the files of a mock inverter. Requests time out after
+// 500ms, lookups are cached. The returned function unmounts and checks for leaked goroutines.
+func mountMock(t *testing.T, files fstest.MapFS) (*tests.SMAMock, string, func()) {
+	requireFUSE(t)
+	goroutines := runtime.NumGoroutine()
+
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", files))
+	httpServer := httptest.NewServer(mock)
+	transport := &http.Transport{}
+	api := &sma.SMAApi{Base: httpServer.URL, Client: http.Client{Transport: transport, Timeout: 500 * time.Millisecond}}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	root := NewFuseFS(context.WithValue(context.Background(), types.ApiContextKey("sid"), sid), backend.NewHTTP(api))
+
+	// cached lookups keep the requests of each operation predictable
+	timeout := time.Hour
+	dir := t.TempDir()
+	server := mount(t, dir, root, &fs.Options{EntryTimeout: &timeout, AttrTimeout: &timeout})
+
+	return mock, dir, func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("error during unmount: %v", err)
+		}
+		server.Wait()
+		transport.CloseIdleConnections()
+		httpServer.Close()
+
+		// goroutines of the server and connections end asynchronously
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > goroutines {
+			buf := make([]byte, 1<<16)
+			t.Errorf("%d goroutines leaked:\n%s", n-goroutines, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	files := fstest.MapFS{
+		"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")},
+		"DIAGNOSE/file2.txt": &fstest.MapFile{Data: []byte("file2.txt content\n")},
+		"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n")},
+	}
+	mock, dir, unmount := mountMock(t, files)
+	defer unmount()
+
+	readDir := func() error {
+		entries, err := os.ReadDir(dir + "/DIAGNOSE")
+		if err == nil && len(entries) != 2 {
+			t.Errorf("Expected 2 entries, got %v", entries)
+		}
+		return err
+	}
+	readFile := func() error {
+		content, err := os.ReadFile(dir + "/DIAGNOSE/file1.txt")
+		if err == nil && string(content) != "file1.txt content\n" {
+			t.Errorf("Invalid content: %q", content)
+		}
+		return err
+	}
+
+	for _, c := range []struct {
+		name     string
+		fault    tests.Fault
+		op       func() error
+		expected syscall.Errno
+	}{
+		// downloads are retried, only failing every attempt is an error
+		{"truncated download", tests.Fault{Path: "/fs/", Truncate: true, Count: 3}, readFile, syscall.EIO},
+		{"dropped download", tests.Fault{Path: "/fs/", Drop: true, Count: 3}, readFile, syscall.EIO},
+		{"short download", tests.Fault{Path: "/fs/", Body: "file1", Count: 3}, readFile, syscall.EIO},
+		{"malformed JSON", tests.Fault{Path: "/dyn/getFS.json", Body: `{"result":{"`, Count: 1}, readDir, syscall.EIO},
+		{"slow response", tests.Fault{Path: "/dyn/getFS.json", Delay: time.Second, Count: 1}, readDir, syscall.ETIMEDOUT},
+		{"server error", tests.Fault{Path: "/dyn/getFS.json", Status: http.StatusInternalServerError, Count: 1}, readDir, syscall.EIO},
+	} {
+		if err := c.op(); err != nil {
+			t.Fatalf("%s: error before the fault: %v", c.name, err)
+		}
+		mock.AddFault(c.fault)
+		if err := c.op(); !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		// the fault is used up and the file system recovers
+		if err := c.op(); err != nil {
+			t.Errorf("%s: error after the fault: %v", c.name, err)
+		}
+	}
+
+	// a burst of errors fails each request until it is over
+	mock.AddFault(tests.Fault{Path: "/dyn/getFS.json", Status: http.StatusServiceUnavailable, Count: 3})
+	for idx := 0; idx < 3; idx++ {
+		if err := readDir(); !errors.Is(err, syscall.EIO) {
+			t.Errorf("Expected EIO during the burst, got %v", err)
+		}
+	}
+	if err := readDir(); err != nil {
+		t.Errorf("error after the burst: %v", err)
+	}
+
+	// the session expires in the middle of listing the tree
+	mock.AddFault(tests.Fault{Path: "/dyn/getFS.json", Expire: true, Skip: 1, Count: 1})
+	walked := 0
+	err := filepath.WalkDir(dir, func(p string, d iofs.DirEntry, err error) error {
+		walked++
+		return err
+	})
+	if err != nil {
+		t.Errorf("error walking the tree during session expiry: %v", err)
+	}
+	if walked != 6 || mock.Faults() != 0 {
+		t.Errorf("Expected 6 entries walked with the session expired, got %d with %d faults left", walked, mock.Faults())
+	}
+	mock.ExpireSessions()
+	if err := readFile(); err != nil {
+		t.Errorf("error reading after session expiry: %v", err)
+	}
+
+	// a changed password can not be recovered from
+	mock.ExpireSessions()
+	mock.SetPassword("usr", "changed")
+	if err := readDir(); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("Expected EACCES, got %v", err)
+	}
+	mock.SetPassword("usr", "secret")
+
+	if _, err := os.OpenFile(dir+"/DIAGNOSE/file1.txt", os.O_WRONLY, 0); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected EROFS, got %v", err)
+	}
+	if _, err := os.Stat(dir + "/DIAGNOSE/missing.txt"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT, got %v", err)
 	}
-	t.Logf("dir entries: %v\n", entries)
 }
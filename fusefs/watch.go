@@ -0,0 +1,139 @@
+package fusefs
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Operations of change events
+const (
+	EventCreate = "create"
+	EventModify = "modify"
+	EventDelete = "delete"
+)
+
+// Event describes a change of the remote tree detected by Watch.
+type Event struct {
+	Op   string    `json:"op"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// Watch polls the directories known to the kernel every interval until done
+// is closed. Changes against the previous listing invalidate the kernel
+// cache, so inotify watchers learn about them, and are passed to notify if
+// not nil.
+func (r *FuseNode) Watch(done <-chan struct{}, interval time.Duration, notify func(Event)) {
+	snapshots := make(map[string]map[string]types.FSEntry)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.poll(snapshots, notify)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists all directories below r that have inodes and compares them to
+// the snapshots of the previous poll.
+func (r *FuseNode) poll(snapshots map[string]map[string]types.FSEntry, notify func(Event)) {
+	seen := make(map[string]bool)
+	var walk func(dir *fs.Inode)
+	walk = func(dir *fs.Inode) {
+		dirPath := path.Join("/", dir.Path(nil))
+		entries, err := r.root.backend.List(r.root.ctx, dirPath)
+		if err != nil {
+			// removed directories are reported by their parent
+			return
+		}
+		seen[dirPath] = true
+
+		current := make(map[string]types.FSEntry, len(entries))
+		for _, entry := range entries {
+			current[backend.EntryName(entry)] = entry
+		}
+		if previous, ok := snapshots[dirPath]; ok {
+			now := time.Now()
+			created, modified, deleted := diffEntries(previous, current)
+			for _, name := range deleted {
+				if child := dir.GetChild(name); child != nil {
+					dir.NotifyDelete(name, child)
+				} else {
+					dir.NotifyEntry(name)
+				}
+				emit(notify, Event{Op: EventDelete, Path: path.Join(dirPath, name), Time: now})
+			}
+			for _, name := range created {
+				dir.NotifyEntry(name)
+				emit(notify, Event{Op: EventCreate, Path: path.Join(dirPath, name), Time: now})
+			}
+			for _, name := range modified {
+				if child := dir.GetChild(name); child != nil {
+					if node, ok := child.Operations().(*FuseNode); ok {
+						node.mu.Lock()
+						node.entry = current[name]
+						node.Size = current[name].Size
+						node.mu.Unlock()
+					}
+					child.NotifyContent(0, 0)
+				}
+				dir.NotifyEntry(name)
+				emit(notify, Event{Op: EventModify, Path: path.Join(dirPath, name), Time: now})
+			}
+		}
+		snapshots[dirPath] = current
+
+		for _, child := range dir.Children() {
+			if child.IsDir() {
+				walk(child)
+			}
+		}
+	}
+	walk(&r.Inode)
+
+	for dirPath := range snapshots {
+		if !seen[dirPath] {
+			delete(snapshots, dirPath)
+		}
+	}
+}
+
+func emit(notify func(Event), event Event) {
+	if notify != nil {
+		notify(event)
+	}
+}
+
+// diffEntries compares two listings of a directory by name. Entries that
+// changed between file and directory are reported as deleted and created.
+func diffEntries(previous, current map[string]types.FSEntry) (created, modified, deleted []string) {
+	for name, entry := range current {
+		old, ok := previous[name]
+		switch {
+		case !ok:
+			created = append(created, name)
+		case (old.DirectoryName == "") != (entry.DirectoryName == ""):
+			deleted = append(deleted, name)
+			created = append(created, name)
+		case old.Timestamp != entry.Timestamp || old.Size != entry.Size:
+			modified = append(modified, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(modified)
+	sort.Strings(deleted)
+	return created, modified, deleted
+}
@@ -0,0 +1,97 @@
+package fusefs
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Extended attributes describing the remote entry
+const (
+	XattrDevice   = "user.sma.device"
+	XattrTm       = "user.sma.tm"
+	XattrPath     = "user.sma.path"
+	XattrCached   = "user.sma.cached"
+	XattrFetched  = "user.sma.fetched"
+	XattrChecksum = "user.sma.sha256"
+)
+
+// xattrs returns the extended attributes of r in listing order. Fetch time
+// and checksum are only known once the content was downloaded, the checksum
+// is kept across lookups until the timestamp of the file changes.
+func (r *FuseNode) xattrs() [][2]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attrs := [][2]string{
+		{XattrPath, path.Join("/", r.Path(nil))},
+		{XattrTm, strconv.FormatUint(r.entry.Timestamp, 10)},
+	}
+	if r.entry.Device != "" {
+		attrs = append(attrs, [2]string{XattrDevice, r.entry.Device})
+	}
+	if r.entry.DirectoryName != "" || r.isRoot() {
+		return attrs
+	}
+	if r.fetched.IsZero() {
+		attrs = append(attrs, [2]string{XattrCached, "false"})
+	} else {
+		attrs = append(attrs,
+			[2]string{XattrCached, "true"},
+			[2]string{XattrFetched, r.fetched.UTC().Format(time.RFC3339)},
+		)
+	}
+	if sum := r.root.Checksum(r.entry.Device, path.Join("/", r.Path(nil)), r.entry.Timestamp); sum != "" {
+		attrs = append(attrs, [2]string{XattrChecksum, sum})
+	}
+	return attrs
+}
+
+// isRoot reports if r is the root of the file system.
+func (r *FuseNode) isRoot() bool {
+	return r.Root() == &r.Inode
+}
+
+// copyXattr implements the size negotiation of getxattr and listxattr: an
+// empty dest queries the size, a too small one fails with ERANGE.
+func copyXattr(dest []byte, value []byte) (uint32, syscall.Errno) {
+	if len(dest) == 0 {
+		return uint32(len(value)), 0
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+var _ = (fs.NodeGetxattrer)((*FuseNode)(nil))
+
+func (r *FuseNode) Getxattr(ctx context.Context, attr string, dest []byte) (size uint32, errno syscall.Errno) {
+	start := time.Now()
+	defer func() { logOp(ctx, "getxattr "+attr, path.Join("/", r.Path(nil)), start, errno, nil) }()
+
+	for _, kv := range r.xattrs() {
+		if kv[0] == attr {
+			return copyXattr(dest, []byte(kv[1]))
+		}
+	}
+	return 0, syscall.ENODATA
+}
+
+var _ = (fs.NodeListxattrer)((*FuseNode)(nil))
+
+func (r *FuseNode) Listxattr(ctx context.Context, dest []byte) (size uint32, errno syscall.Errno) {
+	start := time.Now()
+	defer func() { logOp(ctx, "listxattr", path.Join("/", r.Path(nil)), start, errno, nil) }()
+
+	var names []byte
+	for _, kv := range r.xattrs() {
+		names = append(names, kv[0]...)
+		names = append(names, 0)
+	}
+	return copyXattr(dest, names)
+}
@@ -1,6 +1,6 @@
 module github.com/dominikbayerl/go-smafs
 
-go 1.18
+go 1.21
 
 require github.com/hanwen/go-fuse/v2 v2.4.0
 
@@ -0,0 +1,176 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Root is the directory exposing the archived files.
+const Root = "/.history"
+
+// dateLayout names the directories of the archive.
+const dateLayout = "2006-01-02"
+
+// History wraps a backend and keeps a copy of each file read through it in
+// a local archive. The archive is exposed below Root as
+// Root/<date>/<remote path>, where date is the day of the remote
+// modification time, so files remain available after the inverter rotated
+// them out.
+type History struct {
+	*backend.Overlay
+	backend backend.Backend
+	dir     string
+	// retention is how long archived days are kept, 0 keeps them forever
+	retention time.Duration
+	loc       *time.Location
+	now       func() time.Time
+}
+
+// New returns a history archiving to the local directory dir, which is
+// created if missing. Days are determined in loc.
+func New(b backend.Backend, dir string, retention time.Duration, loc *time.Location) (*History, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %v", err)
+	}
+	h := &History{backend: b, dir: dir, retention: retention, loc: loc, now: time.Now}
+	h.Overlay = backend.NewOverlay(archiving{b, h}, Root, backend.NewDir(dir), func() time.Time { return h.now() })
+	return h, nil
+}
+
+var _ = (backend.Backend)((*History)(nil))
+
+// archiving is the remote backend, archiving the files opened.
+type archiving struct {
+	backend.Backend
+	h *History
+}
+
+func (a archiving) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return a.h.open(ctx, name)
+}
+
+// open returns the content of the remote file name and archives it on the
+// way.
+func (h *History) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	entry, err := h.backend.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := h.backend.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failing archive must not break reading the remote file
+	if err := h.store(name, entry, content); err != nil {
+		log.Printf("error archiving %s: %v\n", name, err)
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// archivePath returns the local path of the copy of name.
+func (h *History) archivePath(name string, entry types.FSEntry) string {
+	date := time.Unix(int64(entry.Timestamp), 0).In(h.loc).Format(dateLayout)
+	return filepath.Join(h.dir, date, filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+name), "/")))
+}
+
+// archived reports if the copy of name is up to date.
+func (h *History) archived(name string, entry types.FSEntry) bool {
+	info, err := os.Stat(h.archivePath(name, entry))
+	return err == nil && uint64(info.Size()) == entry.Size && info.ModTime().Unix() == int64(entry.Timestamp)
+}
+
+// store writes content as copy of name, unless it is archived already.
+func (h *History) store(name string, entry types.FSEntry, content []byte) error {
+	if h.archived(name, entry) {
+		return nil
+	}
+	p := h.archivePath(name, entry)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	mtime := time.Unix(int64(entry.Timestamp), 0)
+	if err := os.Chtimes(tmp, mtime, mtime); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Sync archives all remote files below dir that have no up to date copy. Files
+// and directories that fail are skipped, their errors are returned together.
+func (h *History) Sync(ctx context.Context, dir string) error {
+	entries, err := h.backend.List(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("error listing %s: %w", dir, err)
+	}
+	var errs []error
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		name := path.Join(dir, backend.EntryName(entry))
+		if entry.DirectoryName != "" {
+			if err := h.Sync(ctx, name); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if h.archived(name, entry) {
+			continue
+		}
+		rc, err := h.open(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error archiving %s: %w", name, err))
+			continue
+		}
+		rc.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// Prune removes the archived days older than the retention.
+func (h *History) Prune() error {
+	if h.retention <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return fmt.Errorf("error reading archive directory: %v", err)
+	}
+	cutoff := h.now().Add(-h.retention)
+	for _, entry := range entries {
+		day, err := time.ParseInLocation(dateLayout, entry.Name(), h.loc)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		if day.AddDate(0, 0, 1).Before(cutoff) {
+			if err := os.RemoveAll(filepath.Join(h.dir, entry.Name())); err != nil {
+				return fmt.Errorf("error removing %s: %v", entry.Name(), err)
+			}
+		}
+	}
+	return nil
+}
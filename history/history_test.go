@@ -0,0 +1,137 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+)
+
+// failing fails to open the file name.
+type failing struct {
+	backend.Backend
+	name string
+}
+
+func (f failing) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if name == f.name {
+		return nil, errors.New("download failed")
+	}
+	return f.Backend.Open(ctx, name)
+}
+
+func setupTest(t *testing.T) (*History, fstest.MapFS) {
+	m := fstest.MapFS{
+		"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n"), ModTime: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)},
+	}
+	h, err := New(backend.NewMemory(m), t.TempDir(), 7*24*time.Hour, time.UTC)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	h.now = func() time.Time { return time.Date(2026, 10, 17, 18, 0, 0, 0, time.UTC) }
+	return h, m
+}
+
+func readAll(t *testing.T, h *History, name string) string {
+	rc, err := h.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Open %s returned an error: %v", name, err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	return string(content)
+}
+
+func TestArchiveOnOpen(t *testing.T) {
+	h, m := setupTest(t)
+	ctx := context.Background()
+
+	if content := readAll(t, h, "/DIAGNOSE/file1.txt"); content != "file1.txt content\n" {
+		t.Errorf("Invalid content: %q", content)
+	}
+
+	// the file stays available after the inverter deleted it
+	delete(m, "DIAGNOSE/file1.txt")
+	if content := readAll(t, h, "/.history/2026-10-16/DIAGNOSE/file1.txt"); content != "file1.txt content\n" {
+		t.Errorf("Invalid archived content: %q", content)
+	}
+	entry, err := h.Stat(ctx, "/.history/2026-10-16/DIAGNOSE/file1.txt")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if entry.Size != 18 || entry.Timestamp != uint64(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC).Unix()) {
+		t.Errorf("Invalid archived entry: %+v", entry)
+	}
+
+	entries, err := h.List(ctx, "/.history")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DirectoryName != "2026-10-16" {
+		t.Errorf("Invalid history listing: %+v", entries)
+	}
+
+	entries, err = h.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	// DIAGNOSE vanished with its only file
+	if len(entries) != 2 || entries[1].DirectoryName != ".history" {
+		t.Errorf("Invalid root listing: %+v", entries)
+	}
+}
+
+func TestSyncPrune(t *testing.T) {
+	h, _ := setupTest(t)
+
+	if err := h.Sync(context.Background(), "/"); err != nil {
+		t.Fatalf("Sync returned an error: %v", err)
+	}
+	for _, p := range []string{"2026-10-16/DIAGNOSE/file1.txt", "2026-10-17/SYSLOG/blarg"} {
+		if _, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(p))); err != nil {
+			t.Errorf("Expected archived copy %s: %v", p, err)
+		}
+	}
+
+	old := filepath.Join(h.dir, "2026-10-01")
+	if err := os.MkdirAll(old, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Prune(); err != nil {
+		t.Fatalf("Prune returned an error: %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be pruned", old)
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, "2026-10-16")); err != nil {
+		t.Errorf("Expected 2026-10-16 to be kept: %v", err)
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	h, m := setupTest(t)
+	m["DIAGNOSE/file2.txt"] = &fstest.MapFile{Data: []byte("file2.txt content\n"), ModTime: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)}
+	h.backend = failing{Backend: h.backend, name: "/DIAGNOSE/file1.txt"}
+
+	// the failing file must not stop the others from being archived
+	err := h.Sync(context.Background(), "/")
+	if err == nil || !strings.Contains(err.Error(), "/DIAGNOSE/file1.txt") {
+		t.Errorf("Expected an error for file1.txt, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, "2026-10-16", "DIAGNOSE", "file1.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no archived copy of file1.txt: %v", err)
+	}
+	for _, p := range []string{"2026-10-16/DIAGNOSE/file2.txt", "2026-10-17/SYSLOG/blarg"} {
+		if _, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(p))); err != nil {
+			t.Errorf("Expected archived copy %s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package iofs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// FS exposes the file tree of a backend as io/fs file system. All
+// operations use ctx, which carries the session of the inverter.
+type FS struct {
+	ctx     context.Context
+	backend backend.Backend
+}
+
+func New(ctx context.Context, b backend.Backend) *FS {
+	return &FS{ctx: ctx, backend: b}
+}
+
+var _ = (fs.ReadDirFS)((*FS)(nil))
+var _ = (fs.StatFS)((*FS)(nil))
+
+// remotePath converts a valid io/fs path to the absolute path of the backend.
+func remotePath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	infos, err := fsys.list(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for idx, info := range infos {
+		entries[idx] = fs.FileInfoToDirEntry(info)
+	}
+	return entries, nil
+}
+
+// list returns the entries of directory name sorted by filename.
+func (fsys *FS) list(name string) ([]*FileInfo, error) {
+	entries, err := fsys.backend.List(fsys.ctx, remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Filename == "" && entry.DirectoryName == "" {
+			continue
+		}
+		infos = append(infos, &FileInfo{entry: entry})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (fsys *FS) stat(name string) (*FileInfo, error) {
+	if name == "." {
+		return &FileInfo{entry: types.FSEntry{DirectoryName: "."}}, nil
+	}
+	entry, err := fsys.backend.Stat(fsys.ctx, remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{entry: entry}, nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &dir{fsys: fsys, name: name, info: info}, nil
+	}
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// FileInfo describes a remote file. Sys returns the underlying types.FSEntry.
+type FileInfo struct {
+	entry types.FSEntry
+}
+
+var _ = (fs.FileInfo)((*FileInfo)(nil))
+
+func (fi *FileInfo) Name() string {
+	return backend.EntryName(fi.entry)
+}
+
+func (fi *FileInfo) Size() int64 {
+	return int64(fi.entry.Size)
+}
+
+func (fi *FileInfo) Mode() fs.FileMode {
+	if fi.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *FileInfo) ModTime() time.Time {
+	return time.Unix(int64(fi.entry.Timestamp), 0)
+}
+
+func (fi *FileInfo) IsDir() bool {
+	return fi.entry.DirectoryName != ""
+}
+
+func (fi *FileInfo) Sys() any {
+	return fi.entry
+}
+
+// file is a remote file whose content is downloaded on first access.
+type file struct {
+	fsys    *FS
+	name    string
+	info    *FileInfo
+	content *bytes.Reader
+}
+
+var _ = (io.ReaderAt)((*file)(nil))
+var _ = (io.Seeker)((*file)(nil))
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) load() error {
+	if f.content != nil {
+		return nil
+	}
+	rc, err := f.fsys.backend.Open(f.fsys.ctx, remotePath(f.name))
+	if err != nil {
+		return &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	f.content = bytes.NewReader(content)
+	return nil
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.Read(b)
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.ReadAt(b, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// dir is an open remote directory, its entries are listed on first ReadDir.
+type dir struct {
+	fsys    *FS
+	name    string
+	info    *FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+var _ = (fs.ReadDirFile)((*dir)(nil))
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
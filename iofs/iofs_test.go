@@ -0,0 +1,67 @@
+package iofs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+var testFS = fstest.MapFS{
+	"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: time.Unix(1684094403, 0)},
+	"DIAGNOSE/file2.txt": &fstest.MapFile{Data: []byte("file2.txt content\n"), ModTime: time.Unix(1694580920, 0)},
+	"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n")},
+}
+
+func setupTest() (*FS, func()) {
+	mock := tests.NewMockServer(testFS)
+	api := sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+	return New(ctx, backend.NewHTTP(&api)), mock.Close
+}
+
+func TestFS(t *testing.T) {
+	fsys, teardown := setupTest()
+	defer teardown()
+
+	if err := fstest.TestFS(fsys, "DIAGNOSE/file1.txt", "DIAGNOSE/file2.txt", "SYSLOG/blarg"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	fsys, teardown := setupTest()
+	defer teardown()
+
+	info, err := fs.Stat(fsys, "DIAGNOSE/file2.txt")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if info.Size() != 18 || info.ModTime().Unix() != 1694580920 || info.IsDir() {
+		t.Errorf("Invalid file info: %+v", info)
+	}
+	if entry, ok := info.Sys().(types.FSEntry); !ok || entry.Filename != "file2.txt" {
+		t.Errorf("Invalid Sys(): %+v", info.Sys())
+	}
+
+	_, err = fs.Stat(fsys, "DIAGNOSE/missing.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemoryFS(t *testing.T) {
+	fsys := New(context.Background(), backend.NewMemory(testFS))
+
+	if err := fstest.TestFS(fsys, "DIAGNOSE/file1.txt", "DIAGNOSE/file2.txt", "SYSLOG/blarg"); err != nil {
+		t.Error(err)
+	}
+}
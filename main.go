@@ -2,78 +2,673 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing/fstest"
+	"text/tabwriter"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/credentials"
+	"github.com/dominikbayerl/go-smafs/export"
 	"github.com/dominikbayerl/go-smafs/fusefs"
+	"github.com/dominikbayerl/go-smafs/history"
+	"github.com/dominikbayerl/go-smafs/iofs"
+	"github.com/dominikbayerl/go-smafs/meter"
+	"github.com/dominikbayerl/go-smafs/modbus"
+	"github.com/dominikbayerl/go-smafs/mqtt"
+	"github.com/dominikbayerl/go-smafs/record"
+	"github.com/dominikbayerl/go-smafs/server"
 	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/speedwire"
 	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/dominikbayerl/go-smafs/values"
+	"github.com/dominikbayerl/go-smafs/views"
 )
 
 type SMAFs struct {
 	fs.Inode
 }
 
+// commands maps subcommands to their entry points, mounting is the default.
+var commands = map[string]func(args []string){
+	"serve":        serveMain,
+	"export":       exportMain,
+	"publish-mqtt": publishMQTTMain,
+	"discover":     discoverMain,
+}
+
 func main() {
-	debug := flag.Bool("debug", false, "print debugging messages.")
-	insecure := flag.Bool("insecure", false, "skip TLS certificate verification")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+	mountMain(os.Args[1:])
+}
+
+func mountMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	debug := flags.Bool("debug", false, "print debugging messages.")
+	conn := addConnFlags(flags)
+	archives := flags.Bool("archives", false, "expose zip and gzip files also as directories of their members, named <archive>.d")
+	withViews := flags.Bool("views", false, "add aggregated yield files below /views")
+	live := flags.Bool("live", false, "add files with the current values below /live, always on for speedwire:// and modbus:// URLs")
+	withMeter := flags.Bool("meter", false, "add the values of Energy Meters on the LAN below /meter")
+	meterInterface := flags.String("meter-interface", "", "network interface to receive Energy Meter datagrams on (default: any)")
+	historyDir := flags.String("history", "", "local directory archiving the files read, exposed below /.history")
+	historyRetention := flags.Duration("history-retention", 0, "how long archived files are kept, 0 keeps them forever")
+	historySync := flags.Duration("history-sync", 0, "interval to archive all remote files, 0 archives only files read")
+	watch := flags.Duration("watch", 0, "poll interval for change notifications, 0 disables them")
+	events := flags.String("events", "", "file to append change events to as JSON lines, - for stdout")
+	logs := addLogFlags(flags)
+	flags.Parse(args)
+	logs.setup()
 
-	if flag.NArg() < 2 {
-		flag.Usage()
+	if flags.NArg() < 2 {
+		flags.Usage()
 		os.Exit(1)
 	}
 
-	u, err := url.Parse(flag.Arg(0))
+	var api *sma.SMAApi
+	var ctx context.Context
+	var b backend.Backend
+	var src values.Source
+	if isSpeedwire(flags.Arg(0)) || isModbus(flags.Arg(0)) {
+		// without the web interface only live values are available
+		var logout func()
+		src, ctx, logout = liveSource(flags.Arg(0), conn, nil)
+		defer logout()
+		if *historyDir != "" || *archives || *withViews {
+			log.Fatal("error -history, -archives and -views need the web interface")
+		}
+		b = backend.NewMemory(fstest.MapFS{})
+	} else {
+		api, ctx = login(flags.Arg(0), conn)
+		defer api.Logout(ctx)
+		b = backend.NewHTTP(api)
+		if *live {
+			src = values.NewWeb(api, nil)
+		}
+	}
+
+	if *historyDir != "" {
+		h, err := history.New(b, *historyDir, *historyRetention, time.Local)
+		if err != nil {
+			log.Fatalf("error opening history: %v\n", err)
+		}
+		go func() {
+			for {
+				if *historySync > 0 {
+					if err := h.Sync(ctx, "/"); err != nil {
+						log.Printf("error archiving files: %v\n", err)
+					}
+				}
+				if err := h.Prune(); err != nil {
+					log.Printf("error pruning history: %v\n", err)
+				}
+				interval := *historySync
+				if interval <= 0 {
+					interval = time.Hour
+				}
+				time.Sleep(interval)
+			}
+		}()
+		b = h
+	}
+	if *archives {
+		b = backend.NewArchive(b)
+	}
+	if *withViews {
+		b = views.New(b, api, time.Local)
+	}
+	if src != nil {
+		b = values.NewLive(b, src)
+	}
+	if *withMeter {
+		receiver := listenMeter(*meterInterface)
+		defer receiver.Close()
+		b = meter.NewBackend(b, receiver)
+	}
+	root := fusefs.NewFuseFS(ctx, b)
+	opts := &fs.Options{}
+	opts.Debug = *debug
+	server, err := fs.Mount(flags.Arg(1), root, opts)
 	if err != nil {
-		log.Fatalf("error invalid url: %v\n", err)
+		log.Fatalf("Mount fail: %v\n", err)
 	}
 
-	if *insecure {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if *watch > 0 {
+		var notify func(fusefs.Event)
+		if *events != "" {
+			out := os.Stdout
+			if *events != "-" {
+				out, err = os.OpenFile(*events, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+				if err != nil {
+					log.Fatalf("error opening event file: %v\n", err)
+				}
+				defer out.Close()
+			}
+			encoder := json.NewEncoder(out)
+			notify = func(event fusefs.Event) {
+				encoder.Encode(event)
+			}
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go root.Watch(done, *watch, notify)
 	}
+	server.Wait()
+}
+
+func serveMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0]+" serve", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "address to listen on")
+	conn := addConnFlags(flags)
+	logs := addLogFlags(flags)
+	flags.Parse(args)
+	logs.setup()
 
-	// Read profile and password from environment variables
-	usernameFile := os.Getenv("SMAFS_USER")
-	if usernameFile == "" {
-		log.Fatal("SMAFS_USER environment variable not set")
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	api, ctx := login(flags.Arg(0), conn)
+	defer api.Logout(ctx)
+
+	log.Printf("serving on http://%s/\n", *listen)
+	if err := http.ListenAndServe(*listen, server.NewServer(iofs.New(ctx, backend.NewHTTP(api)))); err != nil {
+		log.Fatalf("error serving: %v\n", err)
+	}
+}
+
+func exportMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0]+" export", flag.ExitOnError)
+	format := flags.String("format", "csv", "output format: csv, json, influx or parquet")
+	fromFlag := flags.String("from", "", "start of the export as date or RFC 3339 time (default: 24 hours before -to)")
+	toFlag := flags.String("to", "", "end of the export as date or RFC 3339 time (default: now)")
+	source := flags.String("source", "logger", "data source: logger (getLogger endpoint), files (parsed CSV log files) or live (current values)")
+	key := flags.String("key", "5min", "logger data for source logger: 5min or daily")
+	dir := flags.String("path", "/", "remote directory with log files for source files")
+	watermark := flags.String("watermark", "", "file storing the newest exported timestamp for incremental exports")
+	conn := addConnFlags(flags)
+	logs := addLogFlags(flags)
+	flags.Parse(args)
+	logs.setup()
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	write, ok := export.Formats[*format]
+	if !ok {
+		log.Fatalf("error unknown format: %s\n", *format)
+	}
+	opts := export.Options{To: time.Now(), Watermark: *watermark}
+	var err error
+	if *toFlag != "" {
+		if opts.To, err = parseTime(*toFlag); err != nil {
+			log.Fatalf("error invalid -to: %v\n", err)
+		}
+	}
+	opts.From = opts.To.Add(-24 * time.Hour)
+	if *fromFlag != "" {
+		if opts.From, err = parseTime(*fromFlag); err != nil {
+			log.Fatalf("error invalid -from: %v\n", err)
+		}
 	}
-	username, err := os.ReadFile(usernameFile)
+
+	if *source == "live" {
+		// live values carry the time they are fetched at
+		if *toFlag == "" {
+			opts.To = time.Now().Add(time.Hour)
+		}
+		live, ctx, logout := liveSource(flags.Arg(0), conn, nil)
+		defer logout()
+		exportTo(ctx, flags.Arg(1), write, func(ctx context.Context, from, to time.Time) ([]types.Sample, error) {
+			return live.Values(ctx)
+		}, opts)
+		return
+	}
+
+	api, ctx := login(flags.Arg(0), conn)
+	defer api.Logout(ctx)
+
+	var src export.Source
+	switch *source {
+	case "logger":
+		loggerKeys := map[string]int{"5min": sma.LoggerKey5Min, "daily": sma.LoggerKeyDaily}
+		k, ok := loggerKeys[*key]
+		if !ok {
+			log.Fatalf("error unknown logger key: %s\n", *key)
+		}
+		src = export.LoggerSource(api, k)
+	case "files":
+		src = export.FilesSource(backend.NewHTTP(api), *dir, time.Local)
+	default:
+		log.Fatalf("error unknown source: %s\n", *source)
+	}
+
+	exportTo(ctx, flags.Arg(1), write, src, opts)
+}
+
+// exportTo exports the samples of src to the file name, - for stdout.
+func exportTo(ctx context.Context, name string, write export.Writer, src export.Source, opts export.Options) {
+	out := os.Stdout
+	if name != "-" {
+		var err error
+		out, err = os.Create(name)
+		if err != nil {
+			log.Fatalf("error creating output: %v\n", err)
+		}
+		defer out.Close()
+	}
+
+	n, err := export.Export(ctx, out, write, src, opts)
+	if err != nil {
+		log.Fatalf("error exporting: %v\n", err)
+	}
+	log.Printf("exported %d samples\n", n)
+}
+
+func publishMQTTMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0]+" publish-mqtt", flag.ExitOnError)
+	broker := flags.String("broker", "tcp://localhost:1883", "MQTT broker URL (tcp:// or ssl://)")
+	clientID := flags.String("client-id", "go-smafs", "MQTT client ID")
+	mqttUser := flags.String("mqtt-user", "", "MQTT username")
+	mqttPassFile := flags.String("mqtt-pass-file", "", "file containing the MQTT password")
+	topic := flags.String("topic", mqtt.DefaultTopic, "topic template, {device} and {channel} are replaced")
+	discovery := flags.String("discovery-prefix", "", "publish Home Assistant discovery configs below this prefix, e.g. homeassistant")
+	interval := flags.Duration("interval", 10*time.Second, "polling interval")
+	keys := flags.String("keys", "", "comma separated getValues keys (default: AC power, yields, DC power and status), ignored for speedwire://, modbus:// and meter:// URLs")
+	conn := addConnFlags(flags)
+	logs := addLogFlags(flags)
+	flags.Parse(args)
+	logs.setup()
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	opts := mqtt.Options{ClientID: *clientID, Username: *mqttUser, KeepAlive: 2 * *interval}
+	if *mqttPassFile != "" {
+		password, err := os.ReadFile(*mqttPassFile)
+		if err != nil {
+			log.Fatalf("error reading MQTT password from file: %v\n", err)
+		}
+		opts.Password = string(password)
+	}
+	if opts.KeepAlive < time.Minute {
+		opts.KeepAlive = time.Minute
+	}
+
+	var keyList []string
+	if *keys != "" {
+		keyList = strings.Split(*keys, ",")
+	}
+	src, ctx, logout := liveSource(flags.Arg(0), conn, keyList)
+	defer logout()
+	publisher := mqtt.NewPublisher(src, *topic, *discovery)
+
+	runCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	publisher.Run(runCtx, *interval, func() (*mqtt.Client, error) {
+		return mqtt.Dial(*broker, opts)
+	})
+}
+
+func discoverMain(args []string) {
+	flags := flag.NewFlagSet(os.Args[0]+" discover", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 3*time.Second, "time to wait for responses")
+	logs := addLogFlags(flags)
+	flags.Parse(args)
+	logs.setup()
+
+	var serials []uint32
+	for _, arg := range flags.Args() {
+		serial, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			log.Fatalf("error invalid serial: %s\n", arg)
+		}
+		serials = append(serials, uint32(serial))
+	}
+
+	devices := discover(serials, *timeout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tSERIAL\tSUSYID\tURL")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", d.Addr.IP, d.Serial, d.SUSyID, d.URL())
+	}
+	w.Flush()
+}
+
+// discover finds the devices on the LAN using Speedwire. The given serials
+// are looked up by host name if they did not answer.
+func discover(serials []uint32, timeout time.Duration) []speedwire.Device {
+	ctx := context.Background()
+	devices, err := speedwire.Discover(ctx, speedwire.MulticastAddr, timeout)
 	if err != nil {
-		log.Fatalf("error reading username from file: %v\n", err)
+		log.Printf("error discovering devices: %v\n", err)
+	}
+	for _, serial := range serials {
+		found := false
+		for _, d := range devices {
+			found = found || d.Serial == serial
+		}
+		if found {
+			continue
+		}
+		d, err := speedwire.Resolve(ctx, serial, timeout)
+		if err != nil {
+			log.Printf("error finding %s: %v\n", speedwire.Hostname(serial), err)
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// discoverURL replaces the URL "auto" by the one of the only device found on
+// the LAN, "auto:<serial>" by the one of the device with that serial.
+func discoverURL(rawURL string) string {
+	if rawURL != "auto" && !strings.HasPrefix(rawURL, "auto:") {
+		return rawURL
+	}
+	var serials []uint32
+	if s := strings.TrimPrefix(rawURL, "auto"); s != "" {
+		serial, err := strconv.ParseUint(s[1:], 10, 32)
+		if err != nil {
+			log.Fatalf("error invalid serial: %s\n", s[1:])
+		}
+		serials = append(serials, uint32(serial))
+	}
+
+	var candidates []speedwire.Device
+	for _, d := range discover(serials, 3*time.Second) {
+		if len(serials) == 0 || d.Serial == serials[0] {
+			candidates = append(candidates, d)
+		}
+	}
+	if len(candidates) != 1 {
+		log.Fatalf("error %d devices found for %s, pass the URL instead\n", len(candidates), rawURL)
+	}
+	log.Printf("using %s (serial %d)\n", candidates[0].URL(), candidates[0].Serial)
+	return candidates[0].URL()
+}
+
+// parseTime parses a date in local time or an RFC 3339 timestamp.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// login opens a session on the inverter at rawURL. The session ID is stored
+// in the returned context. replay://<dir> answers from the recordings in dir
+// instead.
+func login(rawURL string, conn *connFlags) (*sma.SMAApi, context.Context) {
+	if strings.HasPrefix(rawURL, "replay://") {
+		replayer, err := record.NewReplayer(strings.TrimPrefix(rawURL, "replay://"))
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		// recordings contain neither password nor session ID
+		api := &sma.SMAApi{Base: "http://replay", Client: http.Client{Transport: replayer}}
+		sid, err := api.Login(credentials.DefaultUser, "")
+		if err != nil {
+			log.Fatalf("error requesting session: %v\n", err)
+		}
+		return api, context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
 	}
 
-	passwordFile := os.Getenv("SMAFS_PASS")
-	if passwordFile == "" {
-		log.Fatal("SMAFS_PASS environment variable not set")
+	u, err := url.Parse(discoverURL(rawURL))
+	if err != nil {
+		log.Fatalf("error invalid url: %v\n", err)
 	}
 
-	password, err := os.ReadFile(passwordFile)
+	// the path is kept for inverters behind a reverse proxy
+	base := url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: u.Path}
+	api, err := sma.NewSMAApi(base.String(), conn.options())
 	if err != nil {
-		log.Fatalf("error reading password from file: %v\n", err)
+		log.Fatalf("%v\n", err)
+	}
+	if *conn.record != "" {
+		recorder, err := record.NewRecorder(api.Client.Transport, *conn.record)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		api.Client.Transport = recorder
 	}
 
-	api := sma.SMAApi{Base: fmt.Sprintf("%s://%s", u.Scheme, u.Host), Client: *http.DefaultClient}
-	sid, err := api.Login(string(username), string(password))
+	username, password := conn.userPassword(u.Hostname())
+	sid, err := api.Login(username, password)
 	if err != nil || sid == "" {
 		log.Fatalf("error requesting session: %v\n", err)
 	}
-	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
-	defer api.Logout(ctx)
+	return api, context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+}
 
-	root := fusefs.NewFuseFS(ctx, &api)
-	opts := &fs.Options{}
-	opts.Debug = *debug
-	server, err := fs.Mount(flag.Arg(1), root, opts)
+// isSpeedwire reports whether rawURL selects the Speedwire protocol instead
+// of the web interface.
+func isSpeedwire(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "speedwire://")
+}
+
+// loginSpeedwire opens a session on the device at the speedwire:// URL
+// rawURL. The profile "istl" logs in as installer.
+func loginSpeedwire(rawURL string, conn *connFlags) *speedwire.Client {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		log.Fatalf("Mount fail: %v\n", err)
+		log.Fatalf("error invalid url: %v\n", err)
 	}
-	server.Wait()
+	client, err := speedwire.Dial(u.Host, 2*time.Second)
+	if err != nil {
+		log.Fatalf("error connecting: %v\n", err)
+	}
+	username, password := conn.userPassword(u.Hostname())
+	group := uint32(speedwire.UserGroupUser)
+	if username == "istl" {
+		group = speedwire.UserGroupInstaller
+	}
+	if err := client.Login(group, password); err != nil {
+		log.Fatalf("error requesting session: %v\n", err)
+	}
+	return client
+}
+
+// isModbus reports whether rawURL selects Modbus TCP.
+func isModbus(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "modbus://")
+}
+
+// dialModbus connects to the device at the modbus:// URL rawURL. The query
+// parameter unit overrides the default unit ID 3.
+func dialModbus(rawURL string) *modbus.Client {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatalf("error invalid url: %v\n", err)
+	}
+	unitID := uint64(modbus.DefaultUnitID)
+	if unit := u.Query().Get("unit"); unit != "" {
+		if unitID, err = strconv.ParseUint(unit, 10, 8); err != nil {
+			log.Fatalf("error invalid unit ID: %s\n", unit)
+		}
+	}
+	client, err := modbus.Dial(u.Host, byte(unitID), 2*time.Second)
+	if err != nil {
+		log.Fatalf("error connecting: %v\n", err)
+	}
+	return client
+}
+
+// liveSource returns the current values of the device at rawURL over the
+// web interface, querying keys, over Speedwire or Modbus. meter://<interface>
+// returns the values of the Energy Meters on the LAN. The returned function
+// closes the session.
+func liveSource(rawURL string, conn *connFlags, keys []string) (values.Source, context.Context, func()) {
+	if strings.HasPrefix(rawURL, "meter://") {
+		receiver := listenMeter(strings.TrimPrefix(rawURL, "meter://"))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := receiver.Wait(ctx); err != nil {
+			log.Fatalf("error no Energy Meter datagram received: %v\n", err)
+		}
+		return receiver, context.Background(), func() { receiver.Close() }
+	}
+	if isModbus(rawURL) {
+		client := dialModbus(rawURL)
+		return client, context.Background(), func() { client.Close() }
+	}
+	if isSpeedwire(rawURL) {
+		client := loginSpeedwire(rawURL, conn)
+		return client, context.Background(), func() { client.Logoff() }
+	}
+	api, ctx := login(rawURL, conn)
+	return values.NewWeb(api, keys), ctx, func() { api.Logout(ctx) }
+}
+
+// listenMeter receives Energy Meter datagrams on the interface named ifname,
+// or any if empty.
+func listenMeter(ifname string) *meter.Receiver {
+	var ifi *net.Interface
+	if ifname != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(ifname); err != nil {
+			log.Fatalf("error invalid interface: %v\n", err)
+		}
+	}
+	receiver, err := meter.Listen(ifi, meter.MulticastAddr)
+	if err != nil {
+		log.Fatalf("error receiving Energy Meter datagrams: %v\n", err)
+	}
+	go receiver.Run()
+	return receiver
+}
+
+// logFlags select the level and format of the log output on stderr.
+type logFlags struct {
+	level  *string
+	format *string
+}
+
+func addLogFlags(flags *flag.FlagSet) *logFlags {
+	return &logFlags{
+		level:  flags.String("log-level", "info", "minimum level of log messages: debug, info, warn or error"),
+		format: flags.String("log-format", "text", "format of log messages: text or json"),
+	}
+}
+
+// setup installs the selected logger as default, also for the log package.
+func (l *logFlags) setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*l.level)); err != nil {
+		log.Fatalf("error invalid -log-level: %v\n", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch *l.format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		log.Fatalf("error unknown log format: %s\n", *l.format)
+	}
+}
+
+// connFlags select how to connect to an inverter, they are shared by all
+// commands.
+type connFlags struct {
+	insecure    *bool
+	ca          *string
+	cert        *string
+	key         *string
+	tofu        *bool
+	pins        *string
+	proxy       *string
+	headers     headerFlags
+	record      *string
+	timeout     *time.Duration
+	credentials *string
+}
+
+// headerFlags collect repeated "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("expected \"Name: value\"")
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	return nil
+}
+
+func addConnFlags(flags *flag.FlagSet) *connFlags {
+	c := &connFlags{
+		insecure:    flags.Bool("insecure", false, "skip TLS certificate verification"),
+		ca:          flags.String("ca", "", "PEM bundle of the certificate authorities to trust"),
+		cert:        flags.String("cert", "", "PEM client certificate"),
+		key:         flags.String("cert-key", "", "PEM key of the client certificate"),
+		tofu:        flags.Bool("tofu", false, "trust the inverter certificate on first use and pin it"),
+		pins:        flags.String("pins", sma.DefaultPinFile(), "file of the pinned certificates"),
+		proxy:       flags.String("proxy", "", "URL of an HTTP, HTTPS or SOCKS5 proxy, or direct; by default HTTPS_PROXY is used"),
+		headers:     headerFlags{},
+		timeout:     flags.Duration("timeout", 30*time.Second, "time limit of requests to the web interface, 0 for none"),
+		record:      flags.String("record", "", "directory to save the requests and responses to, without passwords and session IDs"),
+		credentials: flags.String("credentials", credentials.DefaultSpec, "comma separated credential providers tried in order: env (files named by SMAFS_USER and SMAFS_PASS), systemd, keyring or prompt"),
+	}
+	flags.Var(c.headers, "header", "header \"Name: value\" added to every request, may be repeated")
+	return c
+}
+
+// options returns the connection settings selected by the flags.
+func (c *connFlags) options() sma.Options {
+	opts := sma.Options{
+		TLS:     sma.TLSOptions{Insecure: *c.insecure, CAFile: *c.ca, CertFile: *c.cert, KeyFile: *c.key},
+		Proxy:   *c.proxy,
+		Header:  http.Header(c.headers),
+		Timeout: *c.timeout,
+	}
+	if *c.tofu {
+		if *c.pins == "" {
+			log.Fatalf("error -tofu requires -pins\n")
+		}
+		opts.TLS.PinFile = *c.pins
+	}
+	return opts
+}
+
+// userPassword returns profile and password for the inverter at host.
+func (c *connFlags) userPassword(host string) (string, string) {
+	provider, err := credentials.Parse(*c.credentials)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	username, password, err := provider.Credentials(host)
+	if err != nil {
+		log.Fatalf("error reading credentials: %v\n", err)
+	}
+	return username, password
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestFlags defines the flags of every command by running it with -h in a
+// subprocess, as flags defined twice panic before anything is parsed.
+func TestFlags(t *testing.T) {
+	if args := os.Getenv("SMAFS_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"go-smafs"}, strings.Fields(args)...)
+		main()
+		return
+	}
+
+	names := []string{""}
+	for name := range commands {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFlags$")
+		cmd.Env = append(os.Environ(), "SMAFS_TEST_ARGS="+strings.TrimSpace(name+" -h"))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%q -h failed: %v\n%s", name, err, out)
+		}
+	}
+}
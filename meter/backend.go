@@ -0,0 +1,130 @@
+package meter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/export"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Root is the directory containing a directory per meter.
+const Root = "/meter"
+
+// valuesFile is the name of the files of a meter directory, with an
+// extension of export.FileFormats.
+const valuesFile = "values"
+
+// Meters wraps a backend and adds the latest values of each meter below
+// Root/<serial>.
+type Meters struct {
+	*backend.Overlay
+	receiver *Receiver
+	now      func() time.Time
+}
+
+// NewBackend returns b with the readings of receiver added below Root.
+func NewBackend(b backend.Backend, receiver *Receiver) *Meters {
+	m := &Meters{receiver: receiver, now: time.Now}
+	m.Overlay = backend.NewOverlay(b, Root, meterDir{m}, func() time.Time { return m.now() })
+	return m
+}
+
+var _ = (backend.Backend)((*Meters)(nil))
+
+// meterDir serves the meter directories below Root, with paths relative to
+// it.
+type meterDir struct {
+	m *Meters
+}
+
+// split returns the serial and file name of a path relative to Root.
+func split(name string) (serial, file string) {
+	serial, file, _ = strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	return serial, file
+}
+
+// reading returns the latest reading of the meter named serial.
+func (m *Meters) reading(name, serial string) (Reading, error) {
+	n, err := strconv.ParseUint(serial, 10, 32)
+	if err != nil {
+		return Reading{}, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	reading, ok := m.receiver.Reading(uint32(n))
+	if !ok {
+		return Reading{}, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return reading, nil
+}
+
+func (d meterDir) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	serial, file := split(name)
+	if serial == "" {
+		var entries []types.FSEntry
+		for _, reading := range d.m.receiver.Readings() {
+			entries = append(entries, types.FSEntry{DirectoryName: reading.Device(), Timestamp: uint64(reading.Time.Unix()), Device: reading.Device()})
+		}
+		return entries, nil
+	}
+	reading, err := d.m.reading(name, serial)
+	if err != nil || file != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var entries []types.FSEntry
+	for ext := range export.FileFormats {
+		content, _ := generate(reading, valuesFile+ext)
+		entries = append(entries, types.FSEntry{Filename: valuesFile + ext, Size: uint64(len(content)), Timestamp: uint64(reading.Time.Unix()), Device: reading.Device()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Filename < entries[j].Filename })
+	return entries, nil
+}
+
+func (d meterDir) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	serial, file := split(name)
+	reading, err := d.m.reading(name, serial)
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	if file == "" {
+		return types.FSEntry{DirectoryName: serial, Timestamp: uint64(reading.Time.Unix()), Device: reading.Device()}, nil
+	}
+	content, err := generate(reading, file)
+	if err != nil {
+		return types.FSEntry{}, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return types.FSEntry{Filename: file, Size: uint64(len(content)), Timestamp: uint64(reading.Time.Unix()), Device: reading.Device()}, nil
+}
+
+func (d meterDir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	serial, file := split(name)
+	reading, err := d.m.reading(name, serial)
+	if err != nil {
+		return nil, err
+	}
+	content, err := generate(reading, file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// generate encodes the values of reading for name.
+func generate(reading Reading, name string) ([]byte, error) {
+	encode, ok := export.FileFormats[path.Ext(name)]
+	if !ok || strings.TrimSuffix(name, path.Ext(name)) != valuesFile {
+		return nil, fs.ErrNotExist
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, reading.Samples()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,265 @@
+package meter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/dominikbayerl/go-smafs/values"
+)
+
+// MulticastAddr is the group Energy Meters and Home Managers send their
+// measurements to.
+const MulticastAddr = "239.12.255.254:9522"
+
+// protocolMeter is the protocol ID of Energy Meter datagrams.
+const protocolMeter = 0x6069
+
+// OBIS measurement types
+const (
+	typeActual  = 4
+	typeCounter = 8
+	// channelVersion carries the software version instead of a measurement
+	channelVersion = 144
+)
+
+// quantity describes the measurement of an OBIS index, the index modulo 20
+// for the phases.
+type quantity struct {
+	name string
+	// unit and divisor of actual values, counters are in Ws
+	unit    string
+	divisor float64
+	counter string
+	// unit of the counter after dividing by 3600
+	counterUnit string
+}
+
+var quantities = map[uint8]quantity{
+	1:  {name: "P+", unit: "W", divisor: 10, counter: "E+", counterUnit: "Wh"},
+	2:  {name: "P-", unit: "W", divisor: 10, counter: "E-", counterUnit: "Wh"},
+	3:  {name: "Q+", unit: "var", divisor: 10, counter: "EQ+", counterUnit: "varh"},
+	4:  {name: "Q-", unit: "var", divisor: 10, counter: "EQ-", counterUnit: "varh"},
+	9:  {name: "S+", unit: "VA", divisor: 10, counter: "ES+", counterUnit: "VAh"},
+	10: {name: "S-", unit: "VA", divisor: 10, counter: "ES-", counterUnit: "VAh"},
+	11: {name: "I", unit: "A", divisor: 1000},
+	12: {name: "U", unit: "V", divisor: 1000},
+	13: {name: "CosPhi", divisor: 1000},
+	14: {name: "Frequency", unit: "Hz", divisor: 1000},
+}
+
+// Value is a single OBIS measurement.
+type Value struct {
+	// OBIS is the code as channel:index.type.tariff, e.g. 0:1.4.0
+	OBIS    string
+	Channel string
+	Unit    string
+	Value   float64
+}
+
+// Reading is the content of one datagram of a meter.
+type Reading struct {
+	SUSyID uint16
+	Serial uint32
+	// Ticker is the uptime of the meter in milliseconds
+	Ticker  uint32
+	Time    time.Time
+	Version string
+	Values  []Value
+}
+
+// Device returns the serial as used in samples.
+func (r Reading) Device() string {
+	return strconv.FormatUint(uint64(r.Serial), 10)
+}
+
+// Samples returns the values of r.
+func (r Reading) Samples() []types.Sample {
+	samples := make([]types.Sample, 0, len(r.Values))
+	for _, v := range r.Values {
+		samples = append(samples, types.Sample{Device: r.Device(), Channel: v.Channel, Unit: v.Unit, Time: r.Time, Value: v.Value})
+	}
+	return samples
+}
+
+// Decode parses the Energy Meter datagram b received at t.
+func Decode(b []byte, t time.Time) (Reading, error) {
+	if !bytes.HasPrefix(b, []byte("SMA\x00")) || len(b) < 28 {
+		return Reading{}, fmt.Errorf("error no Speedwire datagram")
+	}
+	length := int(binary.BigEndian.Uint16(b[12:]))
+	if binary.BigEndian.Uint16(b[14:]) != 0x0010 || binary.BigEndian.Uint16(b[16:]) != protocolMeter {
+		return Reading{}, fmt.Errorf("error no Energy Meter datagram")
+	}
+	if len(b) < 16+length {
+		return Reading{}, fmt.Errorf("error truncated Energy Meter datagram")
+	}
+
+	r := Reading{
+		SUSyID: binary.BigEndian.Uint16(b[18:]),
+		Serial: binary.BigEndian.Uint32(b[20:]),
+		Ticker: binary.BigEndian.Uint32(b[24:]),
+		Time:   t,
+	}
+	data := b[28 : 16+length]
+	for len(data) >= 4 {
+		channel, index, typ, tariff := data[0], data[1], data[2], data[3]
+		data = data[4:]
+		size := 4
+		if typ == typeCounter {
+			size = 8
+		}
+		if channel == 0 && index == 0 && typ == 0 && tariff == 0 {
+			break
+		} else if len(data) < size {
+			return r, fmt.Errorf("error truncated OBIS value %d:%d.%d.%d", channel, index, typ, tariff)
+		}
+		var raw uint64
+		if size == 8 {
+			raw = binary.BigEndian.Uint64(data)
+		} else {
+			raw = uint64(binary.BigEndian.Uint32(data))
+		}
+		value := data[:size]
+		data = data[size:]
+
+		if channel == channelVersion {
+			r.Version = fmt.Sprintf("%d.%d.%d.%s", value[0], value[1], value[2], releaseType(value[3]))
+			continue
+		}
+		q, ok := quantities[(index-1)%20+1]
+		if !ok || (typ != typeActual && typ != typeCounter) {
+			continue
+		}
+		v := Value{OBIS: fmt.Sprintf("%d:%d.%d.%d", channel, index, typ, tariff), Channel: q.name, Unit: q.unit, Value: float64(raw) / q.divisor}
+		if typ == typeCounter {
+			if q.counter == "" {
+				continue
+			}
+			v.Channel, v.Unit, v.Value = q.counter, q.counterUnit, float64(raw)/3600
+		}
+		if index > 20 {
+			v.Channel = fmt.Sprintf("%s.L%d", v.Channel, (index-1)/20)
+		}
+		r.Values = append(r.Values, v)
+	}
+	return r, nil
+}
+
+// releaseType returns the letter of the release type in software versions.
+func releaseType(t byte) string {
+	if int(t) < len("NEABRS") {
+		return string("NEABRS"[t])
+	}
+	return strconv.Itoa(int(t))
+}
+
+// Receiver collects the latest readings of all meters sending to it.
+type Receiver struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	latest  map[uint32]Reading
+	updated chan struct{}
+}
+
+// NewReceiver returns a receiver reading datagrams from conn.
+func NewReceiver(conn net.PacketConn) *Receiver {
+	return &Receiver{conn: conn, latest: make(map[uint32]Reading), updated: make(chan struct{})}
+}
+
+// Listen joins the multicast group addr, usually MulticastAddr, on the
+// interface ifi, nil for the default.
+func Listen(ifi *net.Interface, addr string) (*Receiver, error) {
+	gaddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", addr, err)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, gaddr)
+	if err != nil {
+		return nil, fmt.Errorf("error joining %s: %v", addr, err)
+	}
+	return NewReceiver(conn), nil
+}
+
+// Run receives datagrams until the connection is closed. Datagrams of other
+// devices are ignored.
+func (r *Receiver) Run() error {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reading, err := Decode(buf[:n], time.Now())
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		r.latest[reading.Serial] = reading
+		close(r.updated)
+		r.updated = make(chan struct{})
+		r.mu.Unlock()
+	}
+}
+
+// Close stops Run.
+func (r *Receiver) Close() error {
+	return r.conn.Close()
+}
+
+// Wait blocks until a reading was received or ctx is done.
+func (r *Receiver) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	if len(r.latest) > 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	updated := r.updated
+	r.mu.Unlock()
+
+	select {
+	case <-updated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Readings returns the latest reading of each meter, sorted by serial.
+func (r *Receiver) Readings() []Reading {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	readings := make([]Reading, 0, len(r.latest))
+	for _, reading := range r.latest {
+		readings = append(readings, reading)
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].Serial < readings[j].Serial })
+	return readings
+}
+
+// Reading returns the latest reading of the meter with serial.
+func (r *Receiver) Reading(serial uint32) (Reading, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reading, ok := r.latest[serial]
+	return reading, ok
+}
+
+var _ = (values.Source)((*Receiver)(nil))
+
+// Values returns the latest values of all meters.
+func (r *Receiver) Values(ctx context.Context) ([]types.Sample, error) {
+	var samples []types.Sample
+	for _, reading := range r.Readings() {
+		samples = append(samples, reading.Samples()...)
+	}
+	return samples, nil
+}
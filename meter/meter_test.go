@@ -0,0 +1,187 @@
+package meter
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+)
+
+// recorded returns the datagram of an Energy Meter 2.0 recorded as hex dump
+func recorded(t *testing.T) []byte {
+	dump, err := os.ReadFile("testdata/em20.hex")
+	if err != nil {
+		t.Fatalf("error reading recorded datagram: %v", err)
+	}
+	b, err := hex.DecodeString(strings.Join(strings.Fields(string(dump)), ""))
+	if err != nil {
+		t.Fatalf("error decoding recorded datagram: %v", err)
+	}
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	r, err := Decode(recorded(t), now)
+	if err != nil {
+		t.Fatalf("Decode returned an error: %v", err)
+	}
+	if r.SUSyID != 349 || r.Serial != 3004906004 || r.Ticker != 123456789 || r.Version != "2.0.18.R" || !r.Time.Equal(now) {
+		t.Errorf("Invalid reading: %+v", r)
+	}
+
+	expected := map[string]struct {
+		obis  string
+		unit  string
+		value float64
+	}{
+		"P-":        {"0:2.4.0", "W", 2345.6},
+		"E+":        {"0:1.8.0", "Wh", 1234567},
+		"E-":        {"0:2.8.0", "Wh", 7654321},
+		"CosPhi":    {"0:13.4.0", "", 0.978},
+		"Frequency": {"0:14.4.0", "Hz", 50.012},
+		"I.L1":      {"0:31.4.0", "A", 3.412},
+		"U.L2":      {"0:52.4.0", "V", 230.987},
+		"P-.L3":     {"0:62.4.0", "W", 781.8},
+		"E+.L3":     {"0:61.8.0", "Wh", 411522},
+		"CosPhi.L3": {"0:73.4.0", "", 0.979},
+		"ES-":       {"0:10.8.0", "VAh", 8000000},
+		"Q-":        {"0:4.4.0", "var", 512.3},
+		"EQ+":       {"0:3.8.0", "varh", 100},
+		"S-":        {"0:10.4.0", "VA", 2398.7},
+		"P+":        {"0:1.4.0", "W", 0},
+	}
+	found := 0
+	for _, v := range r.Values {
+		e, ok := expected[v.Channel]
+		if !ok {
+			continue
+		}
+		found++
+		if v.OBIS != e.obis || v.Unit != e.unit || math.Abs(v.Value-e.value) > 1e-9 {
+			t.Errorf("Invalid value %s: %+v", v.Channel, v)
+		}
+	}
+	if found != len(expected) || len(r.Values) != 35 {
+		t.Errorf("Expected %d of %d values, got %+v", len(expected), 35, r.Values)
+	}
+
+	samples := r.Samples()
+	if len(samples) != len(r.Values) || samples[0].Device != "3004906004" || !samples[0].Time.Equal(now) {
+		t.Errorf("Invalid samples: %+v", samples[0])
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	b := recorded(t)
+	if _, err := Decode(b[:100], time.Now()); err == nil {
+		t.Errorf("Expected error for truncated datagram")
+	}
+	other := append([]byte{}, b...)
+	other[17] = 0x65
+	if _, err := Decode(other, time.Now()); err == nil {
+		t.Errorf("Expected error for other protocol")
+	}
+	if _, err := Decode([]byte("HTTP/1.1 200 OK"), time.Now()); err == nil {
+		t.Errorf("Expected error for garbage")
+	}
+}
+
+func setupReceiver(t *testing.T) *Receiver {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error opening socket: %v", err)
+	}
+	r := NewReceiver(conn)
+	go r.Run()
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("error opening socket: %v", err)
+	}
+	defer sender.Close()
+	sender.Write([]byte("SMA\x00 not a meter"))
+	sender.Write(recorded(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Wait(ctx); err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+	return r
+}
+
+func TestReceiver(t *testing.T) {
+	r := setupReceiver(t)
+	defer r.Close()
+
+	readings := r.Readings()
+	if len(readings) != 1 || readings[0].Serial != 3004906004 {
+		t.Fatalf("Invalid readings: %+v", readings)
+	}
+	samples, err := r.Values(context.Background())
+	if err != nil {
+		t.Fatalf("Values returned an error: %v", err)
+	}
+	if len(samples) != 35 {
+		t.Errorf("Expected 35 samples, got %d", len(samples))
+	}
+}
+
+func TestBackend(t *testing.T) {
+	r := setupReceiver(t)
+	defer r.Close()
+	m := fstest.MapFS{"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}
+	b := NewBackend(backend.NewMemory(m), r)
+	ctx := context.Background()
+
+	entries, err := b.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[1].DirectoryName != "meter" {
+		t.Errorf("Invalid root listing: %+v", entries)
+	}
+	entries, err = b.List(ctx, Root)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DirectoryName != "3004906004" {
+		t.Errorf("Invalid meter listing: %+v", entries)
+	}
+	entries, err = b.List(ctx, "/meter/3004906004")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Filename != "values.csv" || entries[1].Filename != "values.json" {
+		t.Errorf("Invalid listing: %+v", entries)
+	}
+
+	entry, err := b.Stat(ctx, "/meter/3004906004/values.csv")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	rc, err := b.Open(ctx, "/meter/3004906004/values.csv")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if !strings.Contains(string(content), "3004906004,Frequency,Hz,50.012") || entry.Size != uint64(len(content)) {
+		t.Errorf("Invalid content with size %d: %s", entry.Size, content)
+	}
+
+	for _, name := range []string{"/meter/42", "/meter/3004906004/values.txt", "/meter/x/values.csv"} {
+		if _, err := b.Stat(ctx, name); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
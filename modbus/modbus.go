@@ -0,0 +1,305 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/dominikbayerl/go-smafs/values"
+)
+
+// DefaultPort is the Modbus TCP port, DefaultUnitID the unit of SMA devices.
+const (
+	DefaultPort   = 502
+	DefaultUnitID = 3
+)
+
+// funcReadHolding reads holding registers, SMA devices answer it for all
+// registers.
+const funcReadHolding = 0x03
+
+// Type is the data type of a register.
+type Type int
+
+const (
+	U32 Type = iota
+	S32
+	U64
+)
+
+// words returns the number of 16 bit registers of t.
+func (t Type) words() uint16 {
+	if t == U64 {
+		return 4
+	}
+	return 2
+}
+
+// Format is the number format of a register.
+type Format int
+
+const (
+	// FIX0 to FIX3 are decimal numbers with that many decimal places
+	FIX0 Format = iota
+	FIX1
+	FIX2
+	FIX3
+	// ENUM and TAGLIST hold tag numbers
+	ENUM
+	TAGLIST
+)
+
+// Register describes an SMA Modbus register.
+type Register struct {
+	Address uint16
+	Type    Type
+	Format  Format
+	Channel string
+	Unit    string
+}
+
+// SMA Modbus registers, named as the keys in sma.Channels
+var (
+	RegisterSerial    = Register{Address: 30057, Type: U32, Format: FIX0, Channel: "Serial"}
+	RegisterHealth    = Register{Address: 30201, Type: U32, Format: ENUM, Channel: "Operation.Health"}
+	RegisterTotWhOut  = Register{Address: 30529, Type: U32, Format: FIX0, Channel: "Metering.TotWhOut", Unit: "Wh"}
+	RegisterDyWhOut   = Register{Address: 30535, Type: U32, Format: FIX0, Channel: "Metering.DyWhOut", Unit: "Wh"}
+	RegisterDcAmp1    = Register{Address: 30769, Type: S32, Format: FIX3, Channel: "DcMs.Amp[1]", Unit: "A"}
+	RegisterDcVol1    = Register{Address: 30771, Type: S32, Format: FIX2, Channel: "DcMs.Vol[1]", Unit: "V"}
+	RegisterDcWatt1   = Register{Address: 30773, Type: S32, Format: FIX0, Channel: "DcMs.Watt[1]", Unit: "W"}
+	RegisterTotW      = Register{Address: 30775, Type: S32, Format: FIX0, Channel: "GridMs.TotW", Unit: "W"}
+	RegisterPhVphsA   = Register{Address: 30783, Type: U32, Format: FIX2, Channel: "GridMs.PhV.phsA", Unit: "V"}
+	RegisterHz        = Register{Address: 30803, Type: U32, Format: FIX2, Channel: "GridMs.Hz", Unit: "Hz"}
+	RegisterDcAmp2    = Register{Address: 30957, Type: S32, Format: FIX3, Channel: "DcMs.Amp[2]", Unit: "A"}
+	RegisterDcVol2    = Register{Address: 30959, Type: S32, Format: FIX2, Channel: "DcMs.Vol[2]", Unit: "V"}
+	RegisterDcWatt2   = Register{Address: 30961, Type: S32, Format: FIX0, Channel: "DcMs.Watt[2]", Unit: "W"}
+	RegisterTotWhOutL = Register{Address: 30513, Type: U64, Format: FIX0, Channel: "Metering.TotWhOut", Unit: "Wh"}
+)
+
+// DefaultRegisters are the registers of Values, matching sma.DefaultKeys.
+var DefaultRegisters = []Register{RegisterTotW, RegisterTotWhOut, RegisterDyWhOut, RegisterDcWatt1, RegisterDcWatt2, RegisterHealth}
+
+// Decode returns the value of r from its registers, false if it is NaN.
+func (r Register) Decode(words []uint16) (float64, bool) {
+	if len(words) < int(r.Type.words()) {
+		return 0, false
+	}
+	var v float64
+	switch r.Type {
+	case U32:
+		raw := uint32(words[0])<<16 | uint32(words[1])
+		if raw == 0xffffffff || (r.Format == ENUM || r.Format == TAGLIST) && raw == 0x00fffffd {
+			return 0, false
+		}
+		v = float64(raw)
+	case S32:
+		raw := uint32(words[0])<<16 | uint32(words[1])
+		if raw == 0x80000000 {
+			return 0, false
+		}
+		v = float64(int32(raw))
+	case U64:
+		raw := uint64(words[0])<<48 | uint64(words[1])<<32 | uint64(words[2])<<16 | uint64(words[3])
+		if raw == 0xffffffffffffffff {
+			return 0, false
+		}
+		v = float64(raw)
+	}
+	switch r.Format {
+	case FIX1:
+		v /= 10
+	case FIX2:
+		v /= 100
+	case FIX3:
+		v /= 1000
+	}
+	return v, true
+}
+
+// ExceptionError is returned for requests answered with a Modbus exception.
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("error modbus exception %#02x for function %#02x", e.Code, e.Function)
+}
+
+// Client reads registers of a single unit over Modbus TCP. After a failed
+// request the connection is closed, as a late response would be taken for
+// the answer to the next one, and established again by the next request.
+type Client struct {
+	addr      string
+	unitID    byte
+	timeout   time.Duration
+	registers []Register
+
+	mu   sync.Mutex
+	conn net.Conn
+	tid  uint16
+}
+
+// Dial connects to unitID at host, with or without port. Responses not
+// received within timeout fail the request.
+func Dial(host string, unitID byte, timeout time.Duration) (*Client, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(DefaultPort))
+	}
+	c := &Client{addr: host, unitID: unitID, timeout: timeout, registers: DefaultRegisters}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect establishes the connection, c.mu must be held.
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", c.addr, err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// disconnect closes the connection, c.mu must be held.
+func (c *Client) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// SetRegisters replaces the registers of Values.
+func (c *Client) SetRegisters(registers []Register) {
+	c.registers = registers
+}
+
+// ReadRegisters returns count registers starting at address.
+func (c *Client) ReadRegisters(address, count uint16) ([]uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	words, err := c.readRegisters(address, count)
+	var exception *ExceptionError
+	if err != nil && !errors.As(err, &exception) {
+		// exceptions are regular responses, the connection stays in sync
+		c.disconnect()
+	}
+	return words, err
+}
+
+// readRegisters sends a request on the connection and reads its response,
+// c.mu must be held.
+func (c *Client) readRegisters(address, count uint16) ([]uint16, error) {
+	c.tid++
+	req := make([]byte, 12)
+	binary.BigEndian.PutUint16(req, c.tid)
+	binary.BigEndian.PutUint16(req[4:], 6)
+	req[6] = c.unitID
+	req[7] = funcReadHolding
+	binary.BigEndian.PutUint16(req[8:], address)
+	binary.BigEndian.PutUint16(req[10:], count)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, fmt.Errorf("error receiving response: %v", err)
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 {
+		return nil, fmt.Errorf("error invalid response length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(c.conn, pdu); err != nil {
+		return nil, fmt.Errorf("error receiving response: %v", err)
+	}
+	if tid := binary.BigEndian.Uint16(header); tid != c.tid {
+		return nil, fmt.Errorf("error response to transaction %d instead of %d", tid, c.tid)
+	} else if protocol := binary.BigEndian.Uint16(header[2:]); protocol != 0 {
+		return nil, fmt.Errorf("error invalid protocol %d in response", protocol)
+	}
+
+	if pdu[0] == funcReadHolding|0x80 && len(pdu) >= 2 {
+		return nil, &ExceptionError{Function: funcReadHolding, Code: pdu[1]}
+	} else if pdu[0] != funcReadHolding || len(pdu) < 2 || int(pdu[1]) != 2*int(count) || len(pdu) < 2+2*int(count) {
+		return nil, fmt.Errorf("error invalid response to function %#02x", funcReadHolding)
+	}
+	words := make([]uint16, count)
+	for idx := range words {
+		words[idx] = binary.BigEndian.Uint16(pdu[2+2*idx:])
+	}
+	return words, nil
+}
+
+// Read returns the value of r, false if it is NaN.
+func (c *Client) Read(r Register) (float64, bool, error) {
+	words, err := c.ReadRegisters(r.Address, r.Type.words())
+	if err != nil {
+		return 0, false, err
+	}
+	v, ok := r.Decode(words)
+	return v, ok, nil
+}
+
+var _ = (values.Source)((*Client)(nil))
+
+// Values returns the values of the configured registers, DefaultRegisters
+// unless changed by SetRegisters. The device is named by its serial. NaN
+// values are skipped.
+func (c *Client) Values(ctx context.Context) ([]types.Sample, error) {
+	serial, ok, err := c.Read(RegisterSerial)
+	if err != nil {
+		return nil, err
+	}
+	device := strconv.Itoa(int(c.unitID))
+	if ok {
+		device = strconv.FormatFloat(serial, 'f', 0, 64)
+	}
+
+	var samples []types.Sample
+	now := time.Now()
+	for _, r := range c.registers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v, ok, err := c.Read(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading register %d: %v", r.Address, err)
+		}
+		if !ok {
+			continue
+		}
+		samples = append(samples, types.Sample{Device: device, Channel: r.Channel, Unit: r.Unit, Time: now, Value: v})
+	}
+	return samples, nil
+}
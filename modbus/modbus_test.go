@@ -0,0 +1,155 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/tests"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		r     Register
+		words []uint16
+		value float64
+		ok    bool
+	}{
+		{Register{Type: S32, Format: FIX0}, []uint16{0x0000, 0x04d2}, 1234, true},
+		{Register{Type: S32, Format: FIX0}, []uint16{0xffff, 0xfff6}, -10, true},
+		{Register{Type: S32, Format: FIX0}, []uint16{0x8000, 0x0000}, 0, false},
+		{Register{Type: U32, Format: FIX2}, []uint16{0x0000, 0x1389}, 50.01, true},
+		{Register{Type: U32, Format: FIX3}, []uint16{0x0000, 0x0d54}, 3.412, true},
+		{Register{Type: U32, Format: FIX1}, []uint16{0x0000, 0x0019}, 2.5, true},
+		{Register{Type: U32, Format: FIX0}, []uint16{0xffff, 0xffff}, 0, false},
+		{Register{Type: U32, Format: ENUM}, []uint16{0x0000, 0x0133}, 307, true},
+		{Register{Type: U32, Format: ENUM}, []uint16{0x00ff, 0xfffd}, 0, false},
+		{Register{Type: U64, Format: FIX0}, []uint16{0x0000, 0x0000, 0x0012, 0xd687}, 1234567, true},
+		{Register{Type: U64, Format: FIX0}, []uint16{0xffff, 0xffff, 0xffff, 0xffff}, 0, false},
+		{Register{Type: U64, Format: FIX0}, []uint16{0x0000}, 0, false},
+	} {
+		v, ok := tc.r.Decode(tc.words)
+		if v != tc.value || ok != tc.ok {
+			t.Errorf("Decode %+v of %04x returned %v, %v, expected %v, %v", tc.r, tc.words, v, ok, tc.value, tc.ok)
+		}
+	}
+}
+
+func setupServer(t *testing.T) *tests.ModbusServer {
+	s, err := tests.NewModbusServer(DefaultUnitID)
+	if err != nil {
+		t.Fatalf("error starting Modbus server: %v", err)
+	}
+	s.SetU32(RegisterSerial.Address, 3005067415)
+	s.SetU32(RegisterTotW.Address, 2345)
+	s.SetU32(RegisterTotWhOut.Address, 1234567)
+	s.SetU32(RegisterDyWhOut.Address, 12000)
+	s.SetU32(RegisterDcWatt1.Address, 1400)
+	// the second input is unused at night
+	s.SetU32(RegisterDcWatt2.Address, 0x80000000)
+	s.SetU32(RegisterHealth.Address, 307)
+	s.SetU32(RegisterHz.Address, 5001)
+	s.SetU64(RegisterTotWhOutL.Address, 1234567)
+	return s
+}
+
+func TestValues(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), DefaultUnitID, time.Second)
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer c.Close()
+
+	samples, err := c.Values(context.Background())
+	if err != nil {
+		t.Fatalf("Values returned an error: %v", err)
+	}
+	expected := map[string]float64{
+		"GridMs.TotW":       2345,
+		"Metering.TotWhOut": 1234567,
+		"Metering.DyWhOut":  12000,
+		"DcMs.Watt[1]":      1400,
+		"Operation.Health":  307,
+	}
+	if len(samples) != len(expected) {
+		t.Errorf("Expected %d samples, got %+v", len(expected), samples)
+	}
+	for _, sample := range samples {
+		if v, ok := expected[sample.Channel]; !ok || v != sample.Value || sample.Device != "3005067415" {
+			t.Errorf("Invalid sample: %+v", sample)
+		}
+	}
+
+	c.SetRegisters([]Register{RegisterHz, RegisterTotWhOutL})
+	samples, err = c.Values(context.Background())
+	if err != nil {
+		t.Fatalf("Values returned an error: %v", err)
+	}
+	if len(samples) != 2 || samples[0].Value != 50.01 || samples[0].Unit != "Hz" || samples[1].Value != 1234567 {
+		t.Errorf("Invalid samples: %+v", samples)
+	}
+}
+
+func TestException(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), DefaultUnitID, time.Second)
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer c.Close()
+
+	var exception *ExceptionError
+	if _, err := c.ReadRegisters(40000, 2); !errors.As(err, &exception) || exception.Code != 0x02 {
+		t.Errorf("Expected illegal address exception, got %v", err)
+	}
+	// the connection is still usable
+	if v, ok, err := c.Read(RegisterTotW); err != nil || !ok || v != 2345 {
+		t.Errorf("Read returned %v, %v, %v", v, ok, err)
+	}
+
+	other, err := Dial(s.Addr(), 126, time.Second)
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Values(context.Background()); !errors.As(err, &exception) || exception.Code != 0x0b {
+		t.Errorf("Expected gateway exception, got %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+
+	c, err := Dial(s.Addr(), DefaultUnitID, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer c.Close()
+
+	// a timed out request is not answered by the late response
+	s.SetDelay(300 * time.Millisecond)
+	if _, _, err := c.Read(RegisterTotW); err == nil {
+		t.Errorf("Expected timeout")
+	}
+	s.SetDelay(0)
+	if v, ok, err := c.Read(RegisterDyWhOut); err != nil || !ok || v != 12000 {
+		t.Errorf("Read after timeout returned %v, %v, %v", v, ok, err)
+	}
+	if s.Accepted() != 2 {
+		t.Errorf("Expected 2 connections, got %d", s.Accepted())
+	}
+
+	// a dropped connection fails at most one request
+	s.DropConnections()
+	c.Read(RegisterTotW)
+	if v, ok, err := c.Read(RegisterTotW); err != nil || !ok || v != 2345 {
+		t.Errorf("Read after dropped connection returned %v, %v, %v", v, ok, err)
+	}
+}
@@ -0,0 +1,163 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetDisconnect = 14
+)
+
+// Options configure the connection to a broker.
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is announced to the broker, the client has to send a
+	// packet within this interval
+	KeepAlive time.Duration
+	// TLSConfig is used for ssl:// and tls:// brokers
+	TLSConfig *tls.Config
+}
+
+// Client is a minimal MQTT 3.1.1 client publishing with QoS 0.
+type Client struct {
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// Dial connects to the broker at rawURL, e.g. tcp://localhost:1883 or
+// ssl://broker:8883.
+func Dial(rawURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error invalid broker url: %v", err)
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), opts.TLSConfig)
+	default:
+		return nil, fmt.Errorf("error unsupported broker scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to broker: %v", err)
+	}
+
+	c := &Client{conn: conn, w: bufio.NewWriter(conn)}
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+func (c *Client) connect(opts Options) error {
+	var body []byte
+	body = appendString(body, "MQTT")
+	// protocol level 4 is MQTT 3.1.1
+	body = append(body, 4)
+	flags := byte(0x02) // clean session
+	if opts.Username != "" {
+		flags |= 0x80
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags)
+	keepAlive := opts.KeepAlive / time.Second
+	if keepAlive > 0xffff {
+		keepAlive = 0xffff
+	}
+	body = append(body, byte(keepAlive>>8), byte(keepAlive))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+	}
+	if opts.Password != "" {
+		body = appendString(body, opts.Password)
+	}
+	if err := c.writePacket(packetConnect<<4, body); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	var connack [4]byte
+	if _, err := io.ReadFull(c.conn, connack[:]); err != nil {
+		return fmt.Errorf("error reading CONNACK: %v", err)
+	}
+	if connack[0]>>4 != packetConnack {
+		return fmt.Errorf("error unexpected packet type %d", connack[0]>>4)
+	}
+	if connack[3] != 0 {
+		return fmt.Errorf("error connection refused with code %d", connack[3])
+	}
+	return nil
+}
+
+// Publish sends payload to topic with QoS 0.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(packetPublish << 4)
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return c.writePacket(header, body)
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	c.writePacket(packetDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+func (c *Client) writePacket(header byte, body []byte) error {
+	c.w.WriteByte(header)
+	// remaining length as variable byte integer
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		c.w.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+	c.w.Write(body)
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("error writing packet: %v", err)
+	}
+	return nil
+}
+
+// appendString appends s with its 16 bit length prefix.
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
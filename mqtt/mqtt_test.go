@@ -0,0 +1,133 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// fakeSource returns fixed values
+type fakeSource []types.Sample
+
+func (s fakeSource) Values(ctx context.Context) ([]types.Sample, error) {
+	return s, nil
+}
+
+var testSamples = fakeSource{
+	{Device: "2130012345", Channel: "GridMs.TotW", Unit: "W", Value: 1234},
+	{Device: "2130012345", Channel: "Metering.TotWhOut", Unit: "Wh", Value: 5678.5},
+}
+
+// waitMessages waits until the broker received n messages
+func waitMessages(t *testing.T, broker *tests.MQTTBroker, n int) []tests.MQTTMessage {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if messages := broker.Messages(); len(messages) >= n {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d messages, got %v", n, broker.Messages())
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	broker, err := tests.NewMQTTBroker()
+	if err != nil {
+		t.Fatalf("error starting broker: %v", err)
+	}
+	defer broker.Close()
+
+	c, err := Dial(broker.URL(), Options{ClientID: "go-smafs-test", Username: "user", Password: "pass", KeepAlive: time.Minute})
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer c.Close()
+
+	p := NewPublisher(testSamples, DefaultTopic, "")
+	if err := p.Publish(context.Background(), c); err != nil {
+		t.Fatalf("Publish returned an error: %v", err)
+	}
+
+	messages := waitMessages(t, broker, 2)
+	if messages[0].Topic != "sma/2130012345/GridMs.TotW" || string(messages[0].Payload) != "1234" || !messages[0].Retain {
+		t.Errorf("Invalid message: %+v", messages[0])
+	}
+	if messages[1].Topic != "sma/2130012345/Metering.TotWhOut" || string(messages[1].Payload) != "5678.5" {
+		t.Errorf("Invalid message: %+v", messages[1])
+	}
+	if clients := broker.Clients(); len(clients) != 1 || clients[0] != "go-smafs-test" {
+		t.Errorf("Invalid clients: %v", clients)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	broker, err := tests.NewMQTTBroker()
+	if err != nil {
+		t.Fatalf("error starting broker: %v", err)
+	}
+	defer broker.Close()
+
+	c, err := Dial(broker.URL(), Options{ClientID: "go-smafs-test"})
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	defer c.Close()
+
+	p := NewPublisher(testSamples, "solar/{channel}", "homeassistant")
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(context.Background(), c); err != nil {
+			t.Fatalf("Publish returned an error: %v", err)
+		}
+	}
+
+	// discovery configs are only sent once
+	messages := waitMessages(t, broker, 6)
+	if len(messages) != 6 {
+		t.Fatalf("Expected 6 messages, got %d", len(messages))
+	}
+	if messages[2].Topic != "homeassistant/sensor/sma_2130012345/Metering_TotWhOut/config" {
+		t.Errorf("Invalid discovery topic: %s", messages[2].Topic)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(messages[2].Payload, &config); err != nil {
+		t.Fatalf("error decoding discovery config: %v", err)
+	}
+	if config["state_topic"] != "solar/Metering.TotWhOut" || config["device_class"] != "energy" || config["state_class"] != "total_increasing" || config["unit_of_measurement"] != "Wh" {
+		t.Errorf("Invalid discovery config: %v", config)
+	}
+}
+
+func TestRun(t *testing.T) {
+	broker, err := tests.NewMQTTBroker()
+	if err != nil {
+		t.Fatalf("error starting broker: %v", err)
+	}
+	defer broker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	p := NewPublisher(testSamples, DefaultTopic, "")
+	go func() {
+		done <- p.Run(ctx, 10*time.Millisecond, func() (*Client, error) {
+			return Dial(broker.URL(), Options{ClientID: "go-smafs-test"})
+		})
+	}()
+
+	waitMessages(t, broker, 4)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	if _, err := Dial("http://localhost:1883", Options{}); err == nil {
+		t.Error("Expected an error for an unsupported scheme, but got none")
+	}
+}
@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/dominikbayerl/go-smafs/values"
+)
+
+// DefaultTopic is the default template of the state topics.
+const DefaultTopic = "sma/{device}/{channel}"
+
+// Publisher polls a values source and publishes each value to its topic.
+type Publisher struct {
+	source values.Source
+	// topic is the template of the state topics, {device} and
+	// {channel} are replaced by the sample
+	topic string
+	// discoveryPrefix enables Home Assistant discovery if not empty
+	discoveryPrefix string
+	// discovered holds the topics whose discovery config was sent
+	discovered map[string]bool
+}
+
+func NewPublisher(source values.Source, topic, discoveryPrefix string) *Publisher {
+	return &Publisher{source: source, topic: topic, discoveryPrefix: discoveryPrefix, discovered: make(map[string]bool)}
+}
+
+// topicEscaper replaces characters not allowed in topic levels.
+var topicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// Topic returns the state topic of sample s.
+func (p *Publisher) Topic(s types.Sample) string {
+	return strings.NewReplacer(
+		"{device}", topicEscaper.Replace(s.Device),
+		"{channel}", topicEscaper.Replace(s.Channel),
+	).Replace(p.topic)
+}
+
+// Publish polls the source once and publishes its values with c.
+func (p *Publisher) Publish(ctx context.Context, c *Client) error {
+	samples, err := p.source.Values(ctx)
+	if err != nil {
+		return err
+	}
+	return p.publish(c, samples)
+}
+
+func (p *Publisher) publish(c *Client, samples []types.Sample) error {
+	for _, s := range samples {
+		topic := p.Topic(s)
+		if p.discoveryPrefix != "" && !p.discovered[topic] {
+			if err := p.publishDiscovery(c, s, topic); err != nil {
+				return err
+			}
+			p.discovered[topic] = true
+		}
+		if err := c.Publish(topic, []byte(strconv.FormatFloat(s.Value, 'f', -1, 64)), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectID keeps the characters allowed in Home Assistant IDs.
+func objectID(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// deviceClasses maps units to Home Assistant sensor device classes.
+var deviceClasses = map[string]string{
+	"W":  "power",
+	"Wh": "energy",
+	"V":  "voltage",
+	"A":  "current",
+	"Hz": "frequency",
+}
+
+// publishDiscovery announces the sensor of sample s to Home Assistant.
+func (p *Publisher) publishDiscovery(c *Client, s types.Sample, topic string) error {
+	node := objectID("sma_" + s.Device)
+	config := map[string]interface{}{
+		"name":        s.Channel,
+		"state_topic": topic,
+		"unique_id":   node + "_" + objectID(s.Channel),
+		"device": map[string]interface{}{
+			"identifiers":  []string{node},
+			"manufacturer": "SMA",
+			"name":         "SMA " + s.Device,
+		},
+	}
+	if s.Unit != "" {
+		config["unit_of_measurement"] = s.Unit
+		config["state_class"] = "measurement"
+	}
+	if class, ok := deviceClasses[s.Unit]; ok {
+		config["device_class"] = class
+		if class == "energy" {
+			config["state_class"] = "total_increasing"
+		}
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	discoveryTopic := fmt.Sprintf("%s/sensor/%s/%s/config", p.discoveryPrefix, node, objectID(s.Channel))
+	return c.Publish(discoveryTopic, payload, true)
+}
+
+// Run publishes the values every interval until ctx is done. Connections
+// are established with dial and re-established after publish errors.
+func (p *Publisher) Run(ctx context.Context, interval time.Duration, dial func() (*Client, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var c *Client
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+
+	for {
+		if c == nil {
+			var err error
+			if c, err = dial(); err != nil {
+				log.Printf("error connecting to broker: %v\n", err)
+			} else {
+				// a new session may have lost retained configs
+				p.discovered = make(map[string]bool)
+			}
+		}
+
+		if c != nil {
+			samples, err := p.source.Values(ctx)
+			if err != nil {
+				log.Printf("error reading values: %v\n", err)
+			} else if err := p.publish(c, samples); err != nil {
+				log.Printf("error publishing: %v\n", err)
+				c.Close()
+				c = nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
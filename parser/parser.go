@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// File is a parsed SMA CSV file.
+type File struct {
+	// Meta holds the key/value pairs of the version line, e.g.
+	// "Version CSV1|Tool SE|Decimalpoint comma" yields Tool=SE.
+	Meta    map[string]string
+	Columns []Column
+	Records []Record
+}
+
+// Column describes a value column of a file.
+type Column struct {
+	Device  string
+	Serial  string
+	Channel string
+	// Kind is "Counter" or "Analog" if specified
+	Kind string
+	Unit string
+}
+
+// Record is a data line. Values are in column order, missing values are NaN.
+type Record struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Parse reads an SMA CSV file. Timestamps are interpreted in loc, which
+// defaults to UTC.
+func Parse(r io.Reader, loc *time.Location) (*File, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	f := &File{Meta: make(map[string]string)}
+	sep := ";"
+	var header [][]string
+	var layout string
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if layout == "" {
+			switch {
+			case strings.HasPrefix(line, "sep="):
+				sep = strings.TrimPrefix(line, "sep=")
+				continue
+			case strings.HasPrefix(line, "Version "):
+				for _, field := range strings.Split(line, "|") {
+					if key, value, ok := strings.Cut(field, " "); ok {
+						f.Meta[key] = value
+					}
+				}
+				continue
+			}
+
+			fields := strings.Split(line, sep)
+			if fields[0] == "" {
+				header = append(header, fields[1:])
+				continue
+			}
+			// The first line with a leading column holds the timestamp
+			// format followed by the units
+			layout = javaLayout(fields[0])
+			f.Columns = columns(header, fields[1:])
+			continue
+		}
+
+		fields := strings.Split(line, sep)
+		t, err := time.ParseInLocation(layout, fields[0], loc)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp in line %d: %v", lineNo, err)
+		}
+		record := Record{Time: t, Values: make([]float64, len(f.Columns))}
+		for idx := range record.Values {
+			record.Values[idx] = math.NaN()
+			if idx+1 >= len(fields) {
+				continue
+			}
+			value := strings.TrimSpace(fields[idx+1])
+			if value == "" {
+				continue
+			}
+			v, err := parseFloat(value, f.Meta["Decimalpoint"])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing value in line %d: %v", lineNo, err)
+			}
+			record.Values[idx] = v
+		}
+		f.Records = append(f.Records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	if layout == "" {
+		return nil, fmt.Errorf("error missing unit line")
+	}
+	return f, nil
+}
+
+// parseFloat parses a localized number. Without decimal point setting, a
+// comma is taken as decimal separator if the value contains no point.
+func parseFloat(value, decimalpoint string) (float64, error) {
+	switch {
+	case decimalpoint == "comma":
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.ReplaceAll(value, ",", ".")
+	case decimalpoint == "point":
+		value = strings.ReplaceAll(value, ",", "")
+	case !strings.Contains(value, "."):
+		value = strings.ReplaceAll(value, ",", ".")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// columns assigns the header lines to column properties. Files contain a
+// varying subset of device name, serial, channel and kind lines, the channel
+// line is always the last one but the kind line.
+func columns(header [][]string, units []string) []Column {
+	cols := make([]Column, len(units))
+	for idx, unit := range units {
+		cols[idx].Unit = strings.TrimSpace(unit)
+	}
+
+	var rest [][]string
+	for _, line := range header {
+		switch {
+		case allFields(line, isKind):
+			for idx := range cols {
+				cols[idx].Kind = field(line, idx)
+			}
+		case allFields(line, isSerial):
+			for idx := range cols {
+				cols[idx].Serial = strings.TrimSpace(strings.TrimPrefix(field(line, idx), "SN:"))
+			}
+		default:
+			rest = append(rest, line)
+		}
+	}
+	if len(rest) > 0 {
+		for idx := range cols {
+			cols[idx].Channel = field(rest[len(rest)-1], idx)
+		}
+	}
+	if len(rest) > 1 {
+		for idx := range cols {
+			cols[idx].Device = field(rest[0], idx)
+		}
+	}
+	return cols
+}
+
+func field(fields []string, idx int) string {
+	if idx < len(fields) {
+		return strings.TrimSpace(fields[idx])
+	}
+	return ""
+}
+
+func allFields(fields []string, pred func(string) bool) bool {
+	for _, f := range fields {
+		if !pred(strings.TrimSpace(f)) {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+func isKind(s string) bool {
+	return s == "Counter" || s == "Analog"
+}
+
+func isSerial(s string) bool {
+	s = strings.TrimSpace(strings.TrimPrefix(s, "SN:"))
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// javaLayout converts the Java style date format of the unit line, e.g.
+// "dd.MM.yyyy HH:mm:ss", to a Go time layout.
+func javaLayout(format string) string {
+	return strings.NewReplacer(
+		"yyyy", "2006",
+		"yy", "06",
+		"MM", "01",
+		"dd", "02",
+		"HH", "15",
+		"mm", "04",
+		"ss", "05",
+	).Replace(strings.TrimSpace(format))
+}
+
+// Samples flattens the records into one sample per value. Missing values are
+// skipped. Samples are attributed to the device serial, or the device name if
+// the file has no serials.
+func (f *File) Samples() []types.Sample {
+	var samples []types.Sample
+	for _, record := range f.Records {
+		for idx, v := range record.Values {
+			if math.IsNaN(v) {
+				continue
+			}
+			col := f.Columns[idx]
+			device := col.Serial
+			if device == "" {
+				device = col.Device
+			}
+			samples = append(samples, types.Sample{Device: device, Channel: col.Channel, Unit: col.Unit, Time: record.Time, Value: v})
+		}
+	}
+	return samples
+}
+
+// ParseZip parses all CSV members of a zip archive.
+func ParseZip(r io.ReaderAt, size int64, loc *time.Location) ([]*File, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip: %v", err)
+	}
+	var files []*File
+	for _, member := range zr.File {
+		if !strings.EqualFold(path.Ext(member.Name), ".csv") {
+			continue
+		}
+		rc, err := member.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", member.Name, err)
+		}
+		f, err := Parse(rc, loc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", member.Name, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// ParseFile parses the content of a logger file. Zip and gzip compressed
+// files are detected by the extension of name.
+func ParseFile(name string, content []byte, loc *time.Location) ([]*File, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		return ParseZip(bytes.NewReader(content), int64(len(content)), loc)
+	case ".gz":
+		zr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip: %v", err)
+		}
+		defer zr.Close()
+		f, err := Parse(zr, loc)
+		if err != nil {
+			return nil, err
+		}
+		return []*File{f}, nil
+	default:
+		f, err := Parse(bytes.NewReader(content), loc)
+		if err != nil {
+			return nil, err
+		}
+		return []*File{f}, nil
+	}
+}
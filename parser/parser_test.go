@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/tests"
+)
+
+const meanCSV = "sep=;\r\n" +
+	"Version CSV1|Tool SE|Linebreaks CR/LF|Delimiter semicolon|Decimalpoint comma|Precision 3\r\n" +
+	"\r\n" +
+	";SN: 2130012345 SB 5000TL;SN: 2130012345 SB 5000TL\r\n" +
+	";SB 5000TL-21;SB 5000TL-21\r\n" +
+	";2130012345;2130012345\r\n" +
+	";E-Total;Pac\r\n" +
+	";Counter;Analog\r\n" +
+	"dd.MM.yyyy HH:mm:ss;kWh;kW\r\n" +
+	"17.10.2026 12:00:00;1.234,567;3,210\r\n" +
+	"17.10.2026 12:05:00;1.234,812;\r\n"
+
+// zipped returns a zip archive containing content as name
+func zipped(name, content string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create(name)
+	io.WriteString(w, content)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(strings.NewReader(meanCSV), nil)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+
+	if f.Meta["Tool"] != "SE" || f.Meta["Decimalpoint"] != "comma" {
+		t.Errorf("Invalid meta data: %v", f.Meta)
+	}
+	expected := []Column{
+		{Device: "SN: 2130012345 SB 5000TL", Serial: "2130012345", Channel: "E-Total", Kind: "Counter", Unit: "kWh"},
+		{Device: "SN: 2130012345 SB 5000TL", Serial: "2130012345", Channel: "Pac", Kind: "Analog", Unit: "kW"},
+	}
+	if len(f.Columns) != len(expected) {
+		t.Fatalf("Expected %d columns, got %+v", len(expected), f.Columns)
+	}
+	for idx := range expected {
+		if f.Columns[idx] != expected[idx] {
+			t.Errorf("Invalid column %d: %+v", idx, f.Columns[idx])
+		}
+	}
+
+	if len(f.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(f.Records))
+	}
+	if !f.Records[0].Time.Equal(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid timestamp: %v", f.Records[0].Time)
+	}
+	if f.Records[0].Values[0] != 1234.567 || f.Records[0].Values[1] != 3.21 {
+		t.Errorf("Invalid values: %v", f.Records[0].Values)
+	}
+	if !math.IsNaN(f.Records[1].Values[1]) {
+		t.Errorf("Expected missing value to be NaN, got %v", f.Records[1].Values[1])
+	}
+
+	samples := f.Samples()
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(samples))
+	}
+	if samples[2].Device != "2130012345" || samples[2].Channel != "E-Total" || samples[2].Unit != "kWh" || samples[2].Value != 1234.812 {
+		t.Errorf("Invalid sample: %+v", samples[2])
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	f, err := Parse(strings.NewReader(meanCSV), loc)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	if !f.Records[0].Time.Equal(time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid timestamp: %v", f.Records[0].Time)
+	}
+}
+
+func TestParseDecimalPoint(t *testing.T) {
+	csv := ";Pac\nyyyy-MM-dd HH:mm;W\n2026-10-17 12:00;1234.5\n2026-10-17 12:05;12,5\n"
+	f, err := Parse(strings.NewReader(csv), nil)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	if f.Columns[0].Channel != "Pac" || f.Columns[0].Unit != "W" {
+		t.Errorf("Invalid column: %+v", f.Columns[0])
+	}
+	if f.Records[0].Values[0] != 1234.5 || f.Records[1].Values[0] != 12.5 {
+		t.Errorf("Invalid values: %v %v", f.Records[0].Values, f.Records[1].Values)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader(";Pac\n;W\n"), nil); err == nil {
+		t.Error("Expected an error for a file without unit line, but got none")
+	}
+	if _, err := Parse(strings.NewReader(";Pac\ndd.MM.yyyy;W\nfoo;1\n"), nil); err == nil {
+		t.Error("Expected an error for an invalid timestamp, but got none")
+	}
+}
+
+func TestParseFromMockServer(t *testing.T) {
+	m := fstest.MapFS{
+		"DATA/Mean.csv": &fstest.MapFile{Data: []byte(meanCSV)},
+		"DATA/Mean.zip": &fstest.MapFile{Data: zipped("Mean.csv", meanCSV)},
+	}
+	mock := tests.NewMockServer(m)
+	defer mock.Close()
+	b := backend.NewHTTP(&sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient})
+
+	for _, name := range []string{"/DATA/Mean.csv", "/DATA/Mean.zip"} {
+		rc, err := b.Open(context.Background(), name)
+		if err != nil {
+			t.Fatalf("error downloading %s: %v", name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+
+		files, err := ParseFile(name, content, nil)
+		if err != nil {
+			t.Fatalf("ParseFile %s returned an error: %v", name, err)
+		}
+		if len(files) != 1 || len(files[0].Samples()) != 3 {
+			t.Errorf("Invalid result for %s: %+v", name, files)
+		}
+	}
+}
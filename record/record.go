@@ -0,0 +1,346 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// redacted replaces secrets in recordings.
+const redacted = "***"
+
+// secretKeys are JSON fields and query parameters holding secrets: the
+// password of logins and the session ID.
+var secretKeys = map[string]bool{"pass": true, "sid": true}
+
+// skippedHeaders are removed from recordings, secrets and those not
+// matching the sanitized body.
+var skippedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "Content-Length", "Transfer-Encoding", "Date"}
+
+// Exchange is a recorded request and its response. Bodies are stored as
+// text if they are valid UTF-8, else base64 encoded.
+type Exchange struct {
+	Method      string      `json:"method"`
+	Path        string      `json:"path"`
+	Query       string      `json:"query,omitempty"`
+	RequestBody string      `json:"requestBody,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body,omitempty"`
+	BodyBase64  []byte      `json:"bodyBase64,omitempty"`
+}
+
+// body returns the response body.
+func (e *Exchange) body() []byte {
+	if e.BodyBase64 != nil {
+		return e.BodyBase64
+	}
+	return []byte(e.Body)
+}
+
+// sanitizeJSON replaces the values of secretKeys in the JSON document b.
+// Documents without secrets and other content are returned unchanged.
+func sanitizeJSON(b []byte) []byte {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return b
+	}
+	changed := false
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if s, ok := value.(string); ok && secretKeys[key] {
+					changed = changed || s != redacted
+					v[key] = redacted
+				} else {
+					walk(value)
+				}
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(doc)
+	if !changed {
+		return b
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+// sanitizeQuery removes secretKeys from the query rawQuery.
+func sanitizeQuery(rawQuery string) string {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	for key := range secretKeys {
+		query.Del(key)
+	}
+	return query.Encode()
+}
+
+// endpoint returns the path of the inverter endpoint in p, without the
+// prefix of a reverse proxy.
+func endpoint(p string) string {
+	idx := -1
+	for _, root := range []string{"/dyn/", "/fs/"} {
+		if i := strings.Index(p, root); i >= 0 && (idx < 0 || i < idx) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return p
+	}
+	return p[idx:]
+}
+
+// Recorder is a transport saving each exchange with the inverter as JSON
+// file in a directory, with passwords and session IDs removed.
+type Recorder struct {
+	// Transport sends the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	Dir       string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder returns a recorder of the requests sent by transport to dir.
+// Existing recordings in dir are kept, new ones are numbered after the
+// highest existing number.
+func NewRecorder(transport http.RoundTripper, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating recording directory: %v", err)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	seq := 0
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(filepath.Base(name), "-")
+		if n, err := strconv.Atoi(prefix); err == nil && n > seq {
+			seq = n
+		}
+	}
+	return &Recorder{Transport: transport, Dir: dir, seq: seq}, nil
+}
+
+var _ = (http.RoundTripper)((*Recorder)(nil))
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		if requestBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	e := Exchange{
+		Method:      req.Method,
+		Path:        endpoint(req.URL.Path),
+		Query:       sanitizeQuery(req.URL.RawQuery),
+		RequestBody: string(sanitizeJSON(requestBody)),
+		Status:      resp.StatusCode,
+		Header:      resp.Header.Clone(),
+	}
+	for _, name := range skippedHeaders {
+		e.Header.Del(name)
+	}
+	body = sanitizeJSON(body)
+	if utf8.Valid(body) {
+		e.Body = string(body)
+	} else {
+		e.BodyBase64 = body
+	}
+	if err := r.save(&e); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save writes e to the next file of the recording.
+func (r *Recorder) save(e *Exchange) error {
+	content, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	name := strings.NewReplacer("/", "_", ".", "_").Replace(strings.Trim(e.Path, "/"))
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	filename := filepath.Join(r.Dir, fmt.Sprintf("%06d-%s.json", r.seq, name))
+	if err := os.WriteFile(filename, append(content, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing recording: %v", err)
+	}
+	return nil
+}
+
+// Replayer answers requests with recorded responses. It is usable as
+// transport of an HTTP client and as handler of a mock server.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []*Exchange
+	// served counts the responses per request key, repeated requests get
+	// the recorded responses in order, the last one once exhausted
+	served map[string]int
+}
+
+// NewReplayer loads the recordings in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("error no recordings in %s", dir)
+	}
+	sort.Strings(names)
+
+	r := &Replayer{served: make(map[string]int)}
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading recording: %v", err)
+		}
+		var e Exchange
+		if err := json.Unmarshal(content, &e); err != nil {
+			return nil, fmt.Errorf("error parsing recording %s: %v", name, err)
+		}
+		r.exchanges = append(r.exchanges, &e)
+	}
+	return r, nil
+}
+
+// match returns the response to a request. Requests are matched by method,
+// endpoint, query and body; if no body matches, e.g. for getLogger with
+// other times, by method and endpoint only.
+func (r *Replayer) match(method, p, rawQuery string, body []byte) *Exchange {
+	p = endpoint(p)
+	query := sanitizeQuery(rawQuery)
+	requestBody := string(sanitizeJSON(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var exact, loose []*Exchange
+	for _, e := range r.exchanges {
+		if e.Method != method || e.Path != p {
+			continue
+		}
+		loose = append(loose, e)
+		if e.Query == query && e.RequestBody == requestBody {
+			exact = append(exact, e)
+		}
+	}
+	key := strings.Join([]string{method, p, query, requestBody}, "\x00")
+	candidates := exact
+	if len(candidates) == 0 {
+		key = strings.Join([]string{method, p}, "\x00")
+		candidates = loose
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	idx := r.served[key]
+	r.served[key]++
+	if idx >= len(candidates) {
+		idx = len(candidates) - 1
+	}
+	return candidates[idx]
+}
+
+var _ = (http.RoundTripper)((*Replayer)(nil))
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		StatusCode: http.StatusNotFound,
+		Header:     make(http.Header),
+	}
+	content := []byte("no recording\n")
+	if e := r.match(req.Method, req.URL.Path, req.URL.RawQuery, body); e != nil {
+		resp.StatusCode = e.Status
+		resp.Header = e.Header.Clone()
+		if resp.Header == nil {
+			resp.Header = make(http.Header)
+		}
+		content = e.body()
+	}
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.ContentLength = int64(len(content))
+	resp.Body = io.NopCloser(bytes.NewReader(content))
+	return resp, nil
+}
+
+var _ = (http.Handler)((*Replayer)(nil))
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e := r.match(req.Method, req.URL.Path, req.URL.RawQuery, body)
+	if e == nil {
+		http.Error(w, "no recording", http.StatusNotFound)
+		return
+	}
+	for name, values := range e.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(e.Status)
+	w.Write(e.body())
+}
@@ -0,0 +1,148 @@
+package record
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// session logs in, lists DIAGNOSE, downloads a file and logs out.
+func session(t *testing.T, api *sma.SMAApi) ([]types.FSEntry, []byte) {
+	sid, err := api.Login("usr", "secret-password")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	entries, err := api.GetFS(ctx, "/DIAGNOSE")
+	if err != nil {
+		t.Errorf("GetFS returned an error: %v", err)
+	}
+	content, err := api.Download(ctx, "/DIAGNOSE/a b.bin")
+	if err != nil {
+		t.Errorf("Download returned an error: %v", err)
+	}
+	if _, err := api.Logout(ctx); err != nil {
+		t.Errorf("Logout returned an error: %v", err)
+	}
+	return entries, content
+}
+
+func TestRecordReplay(t *testing.T) {
+	m := fstest.MapFS{
+		"DIAGNOSE/a b.bin":     &fstest.MapFile{Data: []byte{0xff, 0xfe, 0x00, 0x01}},
+		"DIAGNOSE/file1.txt":   &fstest.MapFile{Data: []byte("file1.txt content\n")},
+		"SYSLOG/unrelated.txt": &fstest.MapFile{Data: []byte("unrelated\n")},
+	}
+	mock := tests.NewMockServer(m)
+	defer mock.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(nil, dir)
+	if err != nil {
+		t.Fatalf("NewRecorder returned an error: %v", err)
+	}
+	entries, content := session(t, &sma.SMAApi{Base: mock.URL, Client: http.Client{Transport: recorder}})
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(names) != 4 {
+		t.Fatalf("Expected 4 recordings, got %v", names)
+	}
+	for _, name := range names {
+		recording, _ := os.ReadFile(name)
+		if strings.Contains(string(recording), "secret-password") || strings.Contains(string(recording), "test-sid") {
+			t.Errorf("Secrets not removed from %s: %s", name, recording)
+		}
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("NewReplayer returned an error: %v", err)
+	}
+	// the prefix of a reverse proxy is ignored
+	replayEntries, replayContent := session(t, &sma.SMAApi{Base: "http://replay.invalid/plant3", Client: http.Client{Transport: replayer}})
+	if len(replayEntries) != len(entries) || len(entries) != 2 {
+		t.Errorf("Expected %d entries, got %+v", len(entries), replayEntries)
+	}
+	if string(replayContent) != string(content) {
+		t.Errorf("Expected content %q, got %q", content, replayContent)
+	}
+
+	// unknown requests are not found
+	api := &sma.SMAApi{Base: "http://replay.invalid", Client: http.Client{Transport: replayer}}
+	if _, err := api.GetFS(context.Background(), "/SYSLOG"); err == nil {
+		t.Errorf("Expected error for request not recorded")
+	}
+}
+
+func TestRecorderNumbering(t *testing.T) {
+	dir := t.TempDir()
+	// recordings 2 to 4 were deleted
+	for _, name := range []string{"000001-dyn_login_json.json", "000005-dyn_getFS_json.json", "notes.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder, err := NewRecorder(nil, dir)
+	if err != nil {
+		t.Fatalf("NewRecorder returned an error: %v", err)
+	}
+	if err := recorder.save(&Exchange{Method: "POST", Path: "/dyn/logout.json"}); err != nil {
+		t.Fatalf("save returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "000006-dyn_logout_json.json")); err != nil {
+		t.Errorf("Expected recording numbered after the highest one: %v", err)
+	}
+}
+
+func TestReplayServer(t *testing.T) {
+	dir := t.TempDir()
+	recording := `{
+  "method": "POST",
+  "path": "/dyn/getFS.json",
+  "requestBody": "{\"destDev\":[],\"path\":\"/\"}",
+  "status": 200,
+  "header": {"Content-Type": ["application/json"]},
+  "body": "{\"result\":{\"device1\":{\"/\":[{\"d\":\"DIAGNOSE\",\"tm\":1684094407}]}}}"
+}`
+	if err := os.WriteFile(filepath.Join(dir, "000001-dyn_getFS_json.json"), []byte(recording), 0600); err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("NewReplayer returned an error: %v", err)
+	}
+	server := httptest.NewServer(replayer)
+	defer server.Close()
+
+	api := &sma.SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "other-sid")
+	entries, err := api.GetFS(ctx, "/")
+	if err != nil {
+		t.Fatalf("GetFS returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DirectoryName != "DIAGNOSE" || entries[0].Device != "device1" {
+		t.Errorf("Invalid entries: %+v", entries)
+	}
+}
+
+func TestSanitizeJSON(t *testing.T) {
+	for in, expected := range map[string]string{
+		`{"right":"usr","pass":"secret"}`:                  `{"pass":"***","right":"usr"}`,
+		`{"result":{"sid":"abc","big":12345678901234567}}`: `{"result":{"big":12345678901234567,"sid":"***"}}`,
+		`{"result":{"isLogin":false}}`:                     `{"result":{"isLogin":false}}`,
+		"no json":                                          "no json",
+	} {
+		if actual := string(sanitizeJSON([]byte(in))); actual != expected {
+			t.Errorf("sanitizeJSON(%s) = %s, expected %s", in, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server is an HTTP frontend for a file tree, usually the one of an inverter
+// as provided by iofs.FS.
+type Server struct {
+	fsys fs.FS
+}
+
+// Entry is the JSON representation of a directory entry.
+type Entry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func NewServer(fsys fs.FS) *Server {
+	return &Server{fsys: fsys}
+}
+
+var _ = (http.Handler)((*Server)(nil))
+
+// ServeHTTP serves directory listings as HTML or JSON (?format=json),
+// directories as zip archives (?format=zip) and files as downloads.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if info.IsDir() {
+		// Redirect to canonical directory URLs so relative links work
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		switch r.URL.Query().Get("format") {
+		case "json":
+			s.serveJSON(w, name)
+		case "zip":
+			s.serveZip(w, name)
+		default:
+			s.serveIndex(w, name)
+		}
+		return
+	}
+	s.serveFile(w, r, name, info)
+}
+
+// httpError maps file system errors to HTTP status codes. Anything but
+// missing files is blamed on the upstream inverter.
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, fs.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// list returns the entries of directory dir sorted by name.
+func (s *Server) list(dir string) ([]Entry, error) {
+	dirEntries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(dirEntries))
+	for idx, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries[idx] = Entry{Name: info.Name(), IsDir: info.IsDir(), ModTime: info.ModTime().UTC()}
+		if !info.IsDir() {
+			entries[idx].Size = info.Size()
+		}
+	}
+	return entries, nil
+}
+
+func (s *Server) serveJSON(w http.ResponseWriter, dir string) {
+	entries, err := s.list(dir)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// indexTemplate lists a directory. Links are escaped by href, as names may
+// contain characters like '#' or '?' with a meaning in URLs.
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"href": func(name string) string { return (&url.URL{Path: name}).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Dir}}</title></head>
+<body>
+<h1>Index of {{.Dir}}</h1>
+<p><a href="?format=zip">Download as zip</a> | <a href="?format=json">JSON</a></p>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Dir "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{href .Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *Server) serveIndex(w http.ResponseWriter, dir string) {
+	entries, err := s.list(dir)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, struct {
+		Dir     string
+		Entries []Entry
+	}{Dir: path.Join("/", dir), Entries: entries})
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	w.Header().Set("ETag", etag(info))
+	// Conditional and HEAD requests are answered from the listing, without
+	// downloading the file from the inverter
+	if notModified(r, etag(info), info.ModTime()) {
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.Header().Set("Accept-Ranges", "bytes")
+		return
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	// ServeContent sets Content-Length, Last-Modified and handles range
+	// requests
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// notModified reports if the GET or HEAD request r is conditional and the
+// file with etag tag and modification time modTime is unchanged.
+// If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, tag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+func (s *Server) serveZip(w http.ResponseWriter, dir string) {
+	name := path.Base(dir)
+	if dir == "." {
+		name = "root"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+
+	// The archive is streamed, errors after the first write can only
+	// abort the response
+	zw := zip.NewWriter(w)
+	err := fs.WalkDir(s.fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(p, dir+"/")
+		if dir == "." {
+			rel = p
+		}
+		header := &zip.FileHeader{Name: rel, Modified: info.ModTime()}
+		if d.IsDir() {
+			header.Name += "/"
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+
+		header.Method = zip.Deflate
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := s.fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	zw.Close()
+}
+
+// etag derives a strong validator from the remote file metadata.
+func etag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size())
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/iofs"
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/tests"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+var modTime = time.Date(2023, 5, 14, 20, 0, 3, 0, time.UTC)
+
+// setupTest starts a mock inverter serving m and a Server in front of it
+func setupTest(m fstest.MapFS) (*httptest.Server, func()) {
+	mock := tests.NewMockServer(m)
+	api := sma.SMAApi{Base: mock.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+	server := httptest.NewServer(NewServer(iofs.New(ctx, backend.NewHTTP(&api))))
+	return server, func() {
+		server.Close()
+		mock.Close()
+	}
+}
+
+var testFS = fstest.MapFS{
+	"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n"), ModTime: modTime},
+	"DIAGNOSE/file2.txt": &fstest.MapFile{Data: []byte("file2.txt content\n"), ModTime: modTime},
+	"SYSLOG/blarg":       &fstest.MapFile{Data: []byte("blarg content\n"), ModTime: modTime},
+}
+
+func TestIndex(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/DIAGNOSE/")
+	if err != nil {
+		t.Fatalf("error requesting index: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	for _, name := range []string{`href="file1.txt"`, `href="file2.txt"`, `href="../"`} {
+		if !bytes.Contains(body, []byte(name)) {
+			t.Errorf("Index does not contain %s: %s", name, body)
+		}
+	}
+}
+
+func TestIndexEscaping(t *testing.T) {
+	m := fstest.MapFS{
+		"DIAGNOSE/#1?.txt":  &fstest.MapFile{Data: []byte("#1 content\n"), ModTime: modTime},
+		"DIAGNOSE/100%.txt": &fstest.MapFile{Data: []byte("100 content\n"), ModTime: modTime},
+	}
+	server, teardown := setupTest(m)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/DIAGNOSE/")
+	if err != nil {
+		t.Fatalf("error requesting index: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	for href, content := range map[string]string{"%231%3F.txt": "#1 content\n", "100%25.txt": "100 content\n"} {
+		if !bytes.Contains(body, []byte(`href="`+href+`"`)) {
+			t.Errorf("Index does not contain href %s: %s", href, body)
+			continue
+		}
+		resp, err := http.Get(server.URL + "/DIAGNOSE/" + href)
+		if err != nil {
+			t.Fatalf("error downloading file: %v", err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != content {
+			t.Errorf("Link %s returned %q", href, got)
+		}
+	}
+}
+
+func TestRedirectDirectory(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/DIAGNOSE?format=json")
+	if err != nil {
+		t.Fatalf("error requesting directory: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/DIAGNOSE/?format=json" {
+		t.Errorf("Expected redirect to /DIAGNOSE/?format=json, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestJSONListing(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/?format=json")
+	if err != nil {
+		t.Fatalf("error requesting listing: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("error decoding listing: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "DIAGNOSE" || !entries[0].IsDir || entries[1].Name != "SYSLOG" {
+		t.Errorf("Invalid listing: %+v", entries)
+	}
+
+	resp, err = http.Get(server.URL + "/SYSLOG/?format=json")
+	if err != nil {
+		t.Fatalf("error requesting listing: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("error decoding listing: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "blarg" || entries[0].Size != 14 || !entries[0].ModTime.Equal(modTime) {
+		t.Errorf("Invalid listing: %+v", entries)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/DIAGNOSE/file1.txt")
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if string(body) != "file1.txt content\n" {
+		t.Errorf("Invalid content: %q", body)
+	}
+	if resp.Header.Get("Content-Length") != "18" {
+		t.Errorf("Invalid Content-Length: %q", resp.Header.Get("Content-Length"))
+	}
+	if resp.Header.Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+		t.Errorf("Invalid Last-Modified: %q", resp.Header.Get("Last-Modified"))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Missing ETag")
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/DIAGNOSE/file1.txt", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %d", resp.StatusCode)
+	}
+}
+
+func TestConditionalWithoutDownload(t *testing.T) {
+	var downloads atomic.Int32
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", testFS))
+	inverter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fs/") {
+			downloads.Add(1)
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	defer inverter.Close()
+	api := &sma.SMAApi{Base: inverter.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	server := httptest.NewServer(NewServer(iofs.New(ctx, backend.NewHTTP(api))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/DIAGNOSE/file1.txt")
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	resp.Body.Close()
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if n := downloads.Load(); n != 1 {
+		t.Fatalf("Expected 1 download, got %d", n)
+	}
+
+	for _, c := range []struct {
+		method, header, value string
+		status                int
+	}{
+		{"GET", "If-None-Match", etag, http.StatusNotModified},
+		{"GET", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"HEAD", "", "", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(c.method, server.URL+"/DIAGNOSE/file1.txt", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error requesting file: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: expected status %d, got %d", c.method, c.header, c.status, resp.StatusCode)
+		}
+		if c.method == "HEAD" && (resp.ContentLength != 18 || resp.Header.Get("ETag") != etag) {
+			t.Errorf("Invalid HEAD response: %d %v", resp.ContentLength, resp.Header)
+		}
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("Expected no further downloads, got %d", n)
+	}
+
+	// a changed file is downloaded again
+	req, _ := http.NewRequest("GET", server.URL+"/DIAGNOSE/file1.txt", nil)
+	req.Header.Set("If-None-Match", `"0-0"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || downloads.Load() != 2 {
+		t.Errorf("Expected download with status 200, got %d after %d downloads", resp.StatusCode, downloads.Load())
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/DIAGNOSE/missing.txt")
+	if err != nil {
+		t.Fatalf("error requesting file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestZip(t *testing.T) {
+	server, teardown := setupTest(testFS)
+	defer teardown()
+
+	resp, err := http.Get(server.URL + "/?format=zip")
+	if err != nil {
+		t.Fatalf("error requesting zip: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("error reading zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rc, _ := f.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	for name, file := range testFS {
+		if files[name] != string(file.Data) {
+			t.Errorf("Invalid zip member %s: %q", name, files[name])
+		}
+	}
+}
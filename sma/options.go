@@ -0,0 +1,63 @@
+package sma
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Options configure the connection to an inverter.
+type Options struct {
+	TLS TLSOptions
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy, e.g.
+	// socks5://localhost:1080, or "direct" to connect without. If empty the
+	// environment variables HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used.
+	Proxy string
+	// Header is added to every request, e.g. to authenticate at a reverse
+	// proxy
+	Header http.Header
+	// Timeout limits the time of a request including the response body, 0
+	// for no limit
+	Timeout time.Duration
+}
+
+// proxyFunc returns the proxy selection for the proxy URL rawURL.
+func proxyFunc(rawURL string) (func(*http.Request) (*url.URL, error), error) {
+	switch rawURL {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error invalid proxy url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("error unsupported proxy scheme: %s", u.Scheme)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// NewSMAApi returns the API of the inverter at base, e.g.
+// https://sma733147246.lan or https://gw/plant3/sma/, with a transport of
+// its own configured by opts.
+func NewSMAApi(base string, opts Options) (*SMAApi, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("error invalid url: %v", err)
+	}
+	transport, err := opts.TLS.NewTransport(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if transport.Proxy, err = proxyFunc(opts.Proxy); err != nil {
+		return nil, err
+	}
+	logger := slog.Default().With("inverter", u.Host)
+	return &SMAApi{Base: base, Header: opts.Header, Logger: logger, Client: http.Client{Transport: transport, Timeout: opts.Timeout}}, nil
+}
@@ -4,19 +4,104 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dominikbayerl/go-smafs/types"
 )
 
 // SMAApi is a FUSE filesystem that uses an HTTP API for file access.
+// Requests failing with an expired session are repeated once with a session
+// renewed with the credentials of the last Login.
 type SMAApi struct {
+	// Base is the URL of the web interface, it may include a path prefix,
+	// e.g. https://gw/plant3/sma/ behind a reverse proxy
 	Base string
+	// Header is added to every request
+	Header http.Header
+	// Logger receives a debug record per request, slog.Default() if nil
+	Logger *slog.Logger
 	// Runtime
 	Client http.Client
+
+	// profile and password of the last login, to renew expired sessions
+	mu                sync.Mutex
+	profile, password string
+	// renewed maps the session IDs returned by Login to their renewals,
+	// sessions ended by Logout are not renewed
+	renewed   map[string]string
+	loggedOut map[string]bool
+	// renewMu serializes renewals, so concurrent requests failing with the
+	// same expired session open a single new one
+	renewMu sync.Mutex
+}
+
+func (api *SMAApi) logger() *slog.Logger {
+	if api.Logger == nil {
+		return slog.Default()
+	}
+	return api.Logger
+}
+
+// redactSID shortens the session ID sid so it can be logged: the prefix
+// tells sessions apart but is useless to take one over.
+func redactSID(sid string) string {
+	if len(sid) <= 8 {
+		return "***"
+	}
+	return sid[:4] + "***"
+}
+
+// redactURL replaces the session ID in the query of rawURL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if sid := query.Get("sid"); sid != "" {
+		query.Set("sid", redactSID(sid))
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// do sends req with the headers of api and logs the outcome.
+func (api *SMAApi) do(req *http.Request) (*http.Response, error) {
+	for name, values := range api.Header {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+
+	start := time.Now()
+	resp, err := api.Client.Do(req)
+	attrs := []any{"method", req.Method, "endpoint", req.URL.Path, "duration", time.Since(start)}
+	if sid := req.URL.Query().Get("sid"); sid != "" {
+		attrs = append(attrs, "sid", redactSID(sid))
+	}
+	if err != nil {
+		// errors of the client quote the URL including the session ID
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		api.logger().Warn("request failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+	level := slog.LevelDebug
+	if resp.StatusCode >= http.StatusBadRequest {
+		level = slog.LevelWarn
+	}
+	api.logger().Log(req.Context(), level, "request", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
 }
 
 // EnsureTrailingSlash ensures that a string has a trailing slash.
@@ -27,8 +112,117 @@ func EnsureTrailingSlash(input string) string {
 	return input
 }
 
+// ErrInvalidPath is returned for remote paths leaving the root directory or
+// containing invalid characters.
+var ErrInvalidPath = errors.New("invalid remote path")
+
+// ValidName reports whether name is usable as a single file or directory
+// name: not empty, no "." or "..", no slash and no NUL.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// CleanPath returns the absolute, normalized form of the remote path name,
+// with or without leading slash. Paths with ".." elements are rejected
+// instead of being resolved.
+func CleanPath(name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." || strings.ContainsRune(part, 0) {
+			return "", fmt.Errorf("error %q: %w", name, ErrInvalidPath)
+		}
+	}
+	return path.Clean("/" + name), nil
+}
+
+// endpoint returns the URL of the endpoint at the unescaped path p below
+// Base, with the session ID of ctx as query parameter if there is one.
+func (api *SMAApi) endpoint(ctx context.Context, p string) (string, error) {
+	u, err := url.Parse(api.Base)
+	if err != nil {
+		return "", fmt.Errorf("error invalid base url: %v", err)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + p
+	u.RawPath = ""
+	if sid, ok := api.session(ctx); ok {
+		u.RawQuery = url.Values{"sid": {sid}}.Encode()
+	}
+	return u.String(), nil
+}
+
+// session returns the current session ID of ctx: the one returned by Login
+// or, once it expired, its renewal.
+func (api *SMAApi) session(ctx context.Context) (string, bool) {
+	sid, ok := ctx.Value(types.ApiContextKey("sid")).(string)
+	if !ok {
+		return "", false
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if renewed, ok := api.renewed[sid]; ok {
+		return renewed, true
+	}
+	return sid, true
+}
+
+// retry calls f and, if the session of ctx expired, renews it with the
+// credentials of the last Login and calls f again.
+func (api *SMAApi) retry(ctx context.Context, f func() error) error {
+	stale, _ := api.session(ctx)
+	err := f()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		return err
+	}
+	if renewErr := api.renew(ctx, stale); renewErr != nil {
+		return err
+	}
+	return f()
+}
+
+// renew replaces the session stale of ctx, unless another request renewed
+// it in the meantime.
+func (api *SMAApi) renew(ctx context.Context, stale string) error {
+	api.renewMu.Lock()
+	defer api.renewMu.Unlock()
+
+	sid, ok := ctx.Value(types.ApiContextKey("sid")).(string)
+	if !ok {
+		return fmt.Errorf("error renewing session: no session")
+	}
+	if current, _ := api.session(ctx); current != stale {
+		return nil
+	}
+	api.mu.Lock()
+	profile, password, loggedOut := api.profile, api.password, api.loggedOut[sid]
+	api.mu.Unlock()
+	if profile == "" || loggedOut {
+		return fmt.Errorf("error renewing session: not logged in")
+	}
+	renewed, err := api.login(ctx, profile, password)
+	if err != nil {
+		return err
+	}
+	api.logger().Info("session renewed", "sid", redactSID(renewed))
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.renewed == nil {
+		api.renewed = make(map[string]string)
+	}
+	api.renewed[sid] = renewed
+	return nil
+}
+
 func (api *SMAApi) Login(profile, password string) (string, error) {
-	loginURL := fmt.Sprintf("%s/dyn/login.json", api.Base)
+	return api.login(context.Background(), profile, password)
+}
+
+// login opens a session, the request is canceled with ctx.
+func (api *SMAApi) login(ctx context.Context, profile, password string) (string, error) {
+	loginURL, err := api.endpoint(ctx, "/dyn/login.json")
+	if err != nil {
+		return "", err
+	}
 
 	// Define the request payload as a struct
 	requestPayload := struct {
@@ -45,7 +239,7 @@ func (api *SMAApi) Login(profile, password string) (string, error) {
 		return "", fmt.Errorf("error marshaling JSON: %v", err)
 	}
 	// Create a POST request
-	req, err := http.NewRequest("POST", loginURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", loginURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("error creating request: %v", err)
 	}
@@ -55,16 +249,19 @@ func (api *SMAApi) Login(profile, password string) (string, error) {
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 
 	// Send the request using the client
-	resp, err := api.Client.Do(req)
+	resp, err := api.do(req)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
+		return "", fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %v", err)
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+	if err := checkError(responseBody); err != nil {
+		return "", err
 	}
 
 	// Define a struct for parsing the response JSON
@@ -79,13 +276,20 @@ func (api *SMAApi) Login(profile, password string) (string, error) {
 		return "", fmt.Errorf("error unmarshaling response JSON: %v", err)
 	}
 
+	api.mu.Lock()
+	api.profile, api.password = profile, password
+	api.mu.Unlock()
 	return response.Result.SID, nil
 }
 
+// Logout ends the current session of ctx, which is the renewal of its
+// session ID if that expired.
 func (api *SMAApi) Logout(ctx context.Context) (bool, error) {
 	// Define the URL for the Logout endpoint
-	sid := ctx.Value(types.ApiContextKey("sid"))
-	url := fmt.Sprintf("%s/dyn/logout.json?sid=%s", api.Base, sid)
+	url, err := api.endpoint(ctx, "/dyn/logout.json")
+	if err != nil {
+		return false, err
+	}
 
 	// Define an empty payload for the request
 	requestPayload := map[string]interface{}{}
@@ -97,7 +301,7 @@ func (api *SMAApi) Logout(ctx context.Context) (bool, error) {
 	}
 
 	// Create a POST request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return false, fmt.Errorf("error creating request: %v", err)
 	}
@@ -107,16 +311,19 @@ func (api *SMAApi) Logout(ctx context.Context) (bool, error) {
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 
 	// Send the request using the client
-	resp, err := api.Client.Do(req)
+	resp, err := api.do(req)
 	if err != nil {
-		return false, fmt.Errorf("error sending request: %v", err)
+		return false, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return false, fmt.Errorf("error reading response body: %v", err)
+		return false, fmt.Errorf("error reading response body: %w", err)
+	}
+	if err := checkError(responseBody); err != nil {
+		return false, err
 	}
 
 	// Parse the response JSON into a LogoutResponse struct
@@ -129,13 +336,39 @@ func (api *SMAApi) Logout(ctx context.Context) (bool, error) {
 		return false, fmt.Errorf("error unmarshaling response JSON: %v", err)
 	}
 
+	if sid, ok := ctx.Value(types.ApiContextKey("sid")).(string); ok {
+		api.mu.Lock()
+		delete(api.renewed, sid)
+		if api.loggedOut == nil {
+			api.loggedOut = make(map[string]bool)
+		}
+		api.loggedOut[sid] = true
+		api.mu.Unlock()
+	}
 	return !logoutResponse.Result.IsLogin, nil
 }
 
-func (api *SMAApi) GetFS(ctx context.Context, path string) ([]types.FSEntry, error) {
+// GetFS returns the entries of the remote directory path. Entries with
+// names unusable as file names, like "..", are left out.
+func (api *SMAApi) GetFS(ctx context.Context, path string) (result []types.FSEntry, err error) {
+	err = api.retry(ctx, func() (err error) {
+		result, err = api.getFS(ctx, path)
+		return err
+	})
+	return result, err
+}
+
+func (api *SMAApi) getFS(ctx context.Context, path string) ([]types.FSEntry, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+
 	// Define the URL for the GetFS endpoint
-	sid := ctx.Value(types.ApiContextKey("sid"))
-	url := fmt.Sprintf("%s/dyn/getFS.json?sid=%s", api.Base, sid)
+	url, err := api.endpoint(ctx, "/dyn/getFS.json")
+	if err != nil {
+		return nil, err
+	}
 
 	// Define the request payload
 	requestPayload := map[string]interface{}{
@@ -150,7 +383,7 @@ func (api *SMAApi) GetFS(ctx context.Context, path string) ([]types.FSEntry, err
 	}
 
 	// Create a POST request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -160,16 +393,19 @@ func (api *SMAApi) GetFS(ctx context.Context, path string) ([]types.FSEntry, err
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 
 	// Send the request using the client
-	resp, err := api.Client.Do(req)
+	resp, err := api.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if err := checkError(responseBody); err != nil {
+		return nil, err
 	}
 
 	// Parse the response JSON into an FSResponse struct
@@ -190,7 +426,6 @@ func (api *SMAApi) GetFS(ctx context.Context, path string) ([]types.FSEntry, err
 		paths = v
 		break
 	}
-	_ = device // unused
 
 	if len(paths) != 1 {
 		return nil, fmt.Errorf("error multiple path responses not supported")
@@ -207,26 +442,330 @@ func (api *SMAApi) GetFS(ctx context.Context, path string) ([]types.FSEntry, err
 		return nil, fmt.Errorf("error invalid response path. Expected: %v, Actual: %v", path, respPath)
 	}
 
-	return entries, nil
+	valid := entries[:0]
+	for _, entry := range entries {
+		if entry.Filename != "" && !ValidName(entry.Filename) || entry.DirectoryName != "" && !ValidName(entry.DirectoryName) {
+			continue
+		}
+		entry.Device = device
+		valid = append(valid, entry)
+	}
+
+	return valid, nil
+}
+
+// APIError is the error code returned by the web interface instead of a
+// result.
+type APIError struct {
+	Code int
+}
+
+func (e *APIError) Error() string {
+	switch e.Code {
+	case 401:
+		return "error 401: wrong password or session expired"
+	case 503:
+		return "error 503: too many sessions"
+	}
+	return fmt.Sprintf("error %d returned by inverter", e.Code)
+}
+
+// checkError returns the APIError of the response body, if it has one.
+func checkError(body []byte) error {
+	var response struct {
+		Err int `json:"err"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Err != 0 {
+		return &APIError{Code: response.Err}
+	}
+	return nil
+}
+
+// Keys of the getLogger endpoint
+const (
+	// LoggerKey5Min is the total yield counter logged every 5 minutes
+	LoggerKey5Min = 28672
+	// LoggerKeyDaily is the total yield counter logged once a day
+	LoggerKeyDaily = 28704
+)
+
+// GetLogger returns the logged values of key between from and to. Values of
+// the yield counters are returned as channel "TotWhOut" in Wh.
+func (api *SMAApi) GetLogger(ctx context.Context, key int, from, to time.Time) (result []types.Sample, err error) {
+	err = api.retry(ctx, func() (err error) {
+		result, err = api.getLogger(ctx, key, from, to)
+		return err
+	})
+	return result, err
 }
 
-func (api *SMAApi) Download(ctx context.Context, filename string) ([]byte, error) {
-	sid := ctx.Value(types.ApiContextKey("sid"))
-	url := fmt.Sprintf("%s/fs/%s?sid=%s", api.Base, filename, sid)
+func (api *SMAApi) getLogger(ctx context.Context, key int, from, to time.Time) ([]types.Sample, error) {
+	// Define the URL for the GetLogger endpoint
+	url, err := api.endpoint(ctx, "/dyn/getLogger.json")
+	if err != nil {
+		return nil, err
+	}
+
+	// Define the request payload
+	requestPayload := map[string]interface{}{
+		"destDev": []interface{}{},
+		"key":     key,
+		"tStart":  from.Unix(),
+		"tEnd":    to.Unix(),
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	// Convert the payload to JSON
+	requestBody, err := json.Marshal(requestPayload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	// Create a POST request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
-	resp, err := api.Client.Do(req)
+
+	// Set request headers
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+
+	// Send the request using the client
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if err := checkError(responseBody); err != nil {
+		return nil, err
+	}
+
+	// Parse the response JSON into a LoggerResponse struct
+	var loggerResponse types.LoggerResponse
+	if err := json.Unmarshal(responseBody, &loggerResponse); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response JSON: %v", err)
+	}
+
+	var samples []types.Sample
+	for device, entries := range loggerResponse.Devices {
+		for _, entry := range entries {
+			if entry.Value == nil {
+				continue
+			}
+			samples = append(samples, types.Sample{
+				Device:  device,
+				Channel: "TotWhOut",
+				Unit:    "Wh",
+				Time:    time.Unix(int64(entry.Timestamp), 0),
+				Value:   *entry.Value,
+			})
+		}
+	}
+	return samples, nil
+}
+
+// Channel describes a key of the getValues endpoint.
+type Channel struct {
+	Name  string
+	Unit  string
+	Scale float64
+}
+
+// Channels are commonly available keys of the getValues endpoint.
+var Channels = map[string]Channel{
+	"6100_40263F00": {Name: "GridMs.TotW", Unit: "W", Scale: 1},
+	"6100_00465700": {Name: "GridMs.Hz", Unit: "Hz", Scale: 0.01},
+	"6100_00464800": {Name: "GridMs.PhV.phsA", Unit: "V", Scale: 0.01},
+	"6380_40251E00": {Name: "DcMs.Watt", Unit: "W", Scale: 1},
+	"6380_40451F00": {Name: "DcMs.Vol", Unit: "V", Scale: 0.01},
+	"6380_40452100": {Name: "DcMs.Amp", Unit: "A", Scale: 0.001},
+	"6400_00260100": {Name: "Metering.TotWhOut", Unit: "Wh", Scale: 1},
+	"6400_00262200": {Name: "Metering.DyWhOut", Unit: "Wh", Scale: 1},
+	"6180_08214800": {Name: "Operation.Health", Scale: 1},
+}
+
+// DefaultKeys are the getValues keys queried if none are configured.
+var DefaultKeys = []string{"6100_40263F00", "6400_00260100", "6400_00262200", "6380_40251E00", "6180_08214800"}
+
+// GetValues returns the current values of keys. Known keys are scaled and
+// named as in Channels. Keys with several instances, like the DC inputs, get
+// the instance appended to the channel, e.g. "DcMs.Watt[2]". Status values
+// are returned as their tag number.
+func (api *SMAApi) GetValues(ctx context.Context, keys []string) (result []types.Sample, err error) {
+	err = api.retry(ctx, func() (err error) {
+		result, err = api.getValues(ctx, keys)
+		return err
+	})
+	return result, err
+}
+
+func (api *SMAApi) getValues(ctx context.Context, keys []string) ([]types.Sample, error) {
+	// Define the URL for the GetValues endpoint
+	url, err := api.endpoint(ctx, "/dyn/getValues.json")
+	if err != nil {
+		return nil, err
+	}
+
+	// Define the request payload
+	requestPayload := map[string]interface{}{
+		"destDev": []interface{}{},
+		"keys":    keys,
+	}
+
+	// Convert the payload to JSON
+	requestBody, err := json.Marshal(requestPayload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	// Create a POST request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	// Set request headers
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+
+	// Send the request using the client
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if err := checkError(responseBody); err != nil {
+		return nil, err
+	}
+
+	// Parse the response JSON into a ValuesResponse struct
+	var valuesResponse types.ValuesResponse
+	if err := json.Unmarshal(responseBody, &valuesResponse); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response JSON: %v", err)
+	}
+
+	now := time.Now()
+	var samples []types.Sample
+	for device, values := range valuesResponse.Devices {
+		for key, instances := range values {
+			channel, ok := Channels[key]
+			if !ok {
+				channel = Channel{Name: key, Scale: 1}
+			}
+			for instance, entries := range instances {
+				for idx, entry := range entries {
+					v, ok := parseValue(entry.Val)
+					if !ok {
+						continue
+					}
+					name := channel.Name
+					if len(instances) > 1 {
+						name = fmt.Sprintf("%s[%s]", name, instance)
+					}
+					if len(entries) > 1 {
+						name = fmt.Sprintf("%s[%d]", name, idx)
+					}
+					samples = append(samples, types.Sample{Device: device, Channel: name, Unit: channel.Unit, Time: now, Value: v * channel.Scale})
+				}
+			}
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Device != samples[j].Device {
+			return samples[i].Device < samples[j].Device
+		}
+		return samples[i].Channel < samples[j].Channel
+	})
+	return samples, nil
+}
+
+// parseValue decodes a value of the getValues endpoint, which is either a
+// number, null or a list of tags of which the first one is returned.
+func parseValue(raw json.RawMessage) (float64, bool) {
+	var v *float64
+	if err := json.Unmarshal(raw, &v); err == nil {
+		return derefValue(v)
+	}
+	var tags []struct {
+		Tag *float64 `json:"tag"`
+	}
+	if err := json.Unmarshal(raw, &tags); err == nil && len(tags) > 0 {
+		return derefValue(tags[0].Tag)
+	}
+	return 0, false
+}
+
+func derefValue(v *float64) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+// StatusError is returned for downloads answered with an HTTP status other
+// than 200 OK.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error unexpected HTTP status %s", e.Status)
+}
+
+// Download returns the content of the remote file filename. Responses with
+// another status than 200 OK or shorter than their Content-Length fail.
+func (api *SMAApi) Download(ctx context.Context, filename string) (result []byte, err error) {
+	err = api.retry(ctx, func() (err error) {
+		result, err = api.download(ctx, filename)
+		return err
+	})
+	return result, err
+}
+
+func (api *SMAApi) download(ctx context.Context, filename string) ([]byte, error) {
+	filename, err := CleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	url, err := api.endpoint(ctx, "/fs"+filename)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	resp, err := api.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, &APIError{Code: resp.StatusCode}
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %v", err)
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if resp.ContentLength >= 0 && int64(len(responseBody)) != resp.ContentLength {
+		return nil, fmt.Errorf("error incomplete download: %d of %d bytes: %w", len(responseBody), resp.ContentLength, io.ErrUnexpectedEOF)
 	}
 	return responseBody, nil
 }
@@ -1,12 +1,30 @@
 package sma
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"io"
+	"log/slog"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/dominikbayerl/go-smafs/tests"
 	"github.com/dominikbayerl/go-smafs/types"
 )
 
@@ -107,6 +125,11 @@ func TestGetFS(t *testing.T) {
 		t.Errorf("Expected 3 entries, but got %d", len(entries))
 	}
 
+	// Check the device is passed on
+	if entries[0].Device != "device1" {
+		t.Errorf("Expected device1, but got %q", entries[0].Device)
+	}
+
 	// Check the first entry (file)
 	if entries[0].Filename != "file1.txt" || entries[0].DirectoryName != "" || entries[0].Timestamp != 1684094403 || entries[0].Size != 1024 {
 		t.Errorf("Invalid entry[0]: %+v", entries[0])
@@ -199,3 +222,651 @@ func TestGetFS_MultiplePaths(t *testing.T) {
 	}
 	server.Close()
 }
+
+func TestGetFS_InvalidNames(t *testing.T) {
+	responseJSON := `{
+		"result": {
+			"device1": {
+				"/": [
+					{"f": "..", "tm": 1684094403, "s": 1024},
+					{"d": "../etc", "tm": 1684094407},
+					{"f": "file1.txt", "tm": 1694580920, "s": 2048}
+				]
+			}
+		}
+	}`
+	server, api := setupTest(responseJSON)
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+	entries, err := api.GetFS(ctx, "/")
+	if err != nil {
+		t.Fatalf("GetFS returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Filename != "file1.txt" {
+		t.Errorf("Expected only file1.txt, but got %+v", entries)
+	}
+
+	if _, err := api.GetFS(ctx, "/DIAGNOSE/../.."); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected ErrInvalidPath, but got %v", err)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":                 "/",
+		"/":                "/",
+		"DIAGNOSE":         "/DIAGNOSE",
+		"/DIAGNOSE/":       "/DIAGNOSE",
+		"//DIAGNOSE/./a b": "/DIAGNOSE/a b",
+		"/SYSLOG/..log":    "/SYSLOG/..log",
+	} {
+		actual, err := CleanPath(name)
+		if err != nil || actual != expected {
+			t.Errorf("CleanPath(%q) = %q, %v; expected %q", name, actual, err, expected)
+		}
+	}
+	for _, name := range []string{"..", "/../etc/passwd", "DIAGNOSE/../../x", "a\x00b"} {
+		if _, err := CleanPath(name); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("CleanPath(%q) returned %v, expected ErrInvalidPath", name, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	names := []string{"DIAGNOSE/a b.txt", "DIAGNOSE/#1?.txt", "DIAGNOSE/100%.txt", "DIAGNOSE/Störung & Ürsache.log", "DIAGNOSE/+sid=x"}
+	m := fstest.MapFS{}
+	for _, name := range names {
+		m[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	mock := tests.NewMockServer(m)
+	defer mock.Close()
+
+	api := SMAApi{Base: mock.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test&sid")
+
+	entries, err := api.GetFS(ctx, "/DIAGNOSE")
+	if err != nil {
+		t.Fatalf("GetFS returned an error: %v", err)
+	}
+	if len(entries) != len(names) {
+		t.Errorf("Expected %d entries, but got %d", len(names), len(entries))
+	}
+	for _, name := range names {
+		content, err := api.Download(ctx, name)
+		if err != nil {
+			t.Errorf("Download returned an error: %v", err)
+		}
+		if string(content) != name {
+			t.Errorf("Download of %q returned %q", name, content)
+		}
+	}
+
+	if _, err := api.Download(ctx, "/DIAGNOSE/../../etc/passwd"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Expected ErrInvalidPath, but got %v", err)
+	}
+}
+
+// shortTransport answers every request with a body shorter than its
+// Content-Length, like a transport not checking it.
+type shortTransport struct{}
+
+func (shortTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		ContentLength: 10,
+		Body:          io.NopCloser(strings.NewReader("short")),
+		Request:       req,
+	}, nil
+}
+
+func TestDownload_Integrity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fs/error.txt":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		case "/fs/login.txt":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+
+	var statusErr *StatusError
+	if _, err := api.Download(context.Background(), "/missing.txt"); !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, but got %v", err)
+	}
+	if _, err := api.Download(context.Background(), "/error.txt"); !errors.As(err, &statusErr) || statusErr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, but got %v", err)
+	}
+	var apiErr *APIError
+	if _, err := api.Download(context.Background(), "/login.txt"); !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected error 401, but got %v", err)
+	}
+
+	api = SMAApi{Base: "http://inverter.invalid", Client: http.Client{Transport: shortTransport{}}}
+	if _, err := api.Download(context.Background(), "/file.txt"); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, but got %v", err)
+	}
+}
+
+func TestGetLogger(t *testing.T) {
+	responseJSON := `{
+		"result": {
+			"device1": [
+				{"t": 1697493600, "v": 1000},
+				{"t": 1697493900, "v": null},
+				{"t": 1697494200, "v": 1042}
+			]
+		}
+	}`
+	var requestPayload struct {
+		Key    int   `json:"key"`
+		TStart int64 `json:"tStart"`
+		TEnd   int64 `json:"tEnd"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dyn/getLogger.json" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &requestPayload)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responseJSON)
+	}))
+	defer server.Close()
+
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+	samples, err := api.GetLogger(ctx, LoggerKey5Min, time.Unix(1697493600, 0), time.Unix(1697580000, 0))
+	if err != nil {
+		t.Fatalf("GetLogger returned an error: %v", err)
+	}
+
+	if requestPayload.Key != LoggerKey5Min || requestPayload.TStart != 1697493600 || requestPayload.TEnd != 1697580000 {
+		t.Errorf("Invalid request payload: %+v", requestPayload)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, but got %d", len(samples))
+	}
+	if samples[1].Device != "device1" || samples[1].Unit != "Wh" || samples[1].Value != 1042 || samples[1].Time.Unix() != 1697494200 {
+		t.Errorf("Invalid sample: %+v", samples[1])
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	responseJSON := `{
+		"result": {
+			"device1": {
+				"6100_40263F00": {"1": [{"val": 1234}]},
+				"6380_40451F00": {"1": [{"val": 35012}], "2": [{"val": null}]},
+				"6180_08214800": {"1": [{"val": [{"tag": 307}]}]}
+			}
+		}
+	}`
+	var requestPayload struct {
+		Keys []string `json:"keys"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dyn/getValues.json" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &requestPayload)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responseJSON)
+	}))
+	defer server.Close()
+
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "test-sid")
+	samples, err := api.GetValues(ctx, DefaultKeys)
+	if err != nil {
+		t.Fatalf("GetValues returned an error: %v", err)
+	}
+
+	if len(requestPayload.Keys) != len(DefaultKeys) {
+		t.Errorf("Invalid request payload: %+v", requestPayload)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, but got %d: %+v", len(samples), samples)
+	}
+	expected := []types.Sample{
+		{Device: "device1", Channel: "DcMs.Vol[1]", Unit: "V", Value: 350.12},
+		{Device: "device1", Channel: "GridMs.TotW", Unit: "W", Value: 1234},
+		{Device: "device1", Channel: "Operation.Health", Value: 307},
+	}
+	for idx, e := range expected {
+		s := samples[idx]
+		if s.Device != e.Device || s.Channel != e.Channel || s.Unit != e.Unit || s.Value != e.Value {
+			t.Errorf("Invalid sample %d: %+v", idx, s)
+		}
+	}
+}
+
+// newTLSServer returns a TLS server answering logins
+func newTLSServer(clientAuth tls.ClientAuthType) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result":{"sid":"test-sid"}}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: clientAuth}
+	server.StartTLS()
+	return server
+}
+
+func TestTLSPinning(t *testing.T) {
+	server := newTLSServer(tls.NoClientCert)
+	defer server.Close()
+	pinFile := filepath.Join(t.TempDir(), "go-smafs", "known_hosts")
+
+	// the default verification rejects the self-signed certificate
+	api, err := NewSMAApi(server.URL, Options{})
+	if err != nil {
+		t.Fatalf("NewSMAApi returned an error: %v", err)
+	}
+	if _, err := api.Login("foo", "bar"); err == nil {
+		t.Errorf("Expected error for unknown certificate")
+	}
+
+	// trusted on first use
+	for idx := 0; idx < 2; idx++ {
+		api, err = NewSMAApi(server.URL, Options{TLS: TLSOptions{PinFile: pinFile}})
+		if err != nil {
+			t.Fatalf("NewSMAApi returned an error: %v", err)
+		}
+		if _, err := api.Login("foo", "bar"); err != nil {
+			t.Errorf("Login returned an error: %v", err)
+		}
+	}
+	host := strings.TrimPrefix(server.URL, "https://")
+	content, _ := os.ReadFile(pinFile)
+	if string(content) != host+" "+Fingerprint(server.Certificate())+"\n" {
+		t.Errorf("Invalid pin file: %q", content)
+	}
+
+	// the certificate changed
+	if err := os.WriteFile(pinFile, []byte(host+" "+strings.Repeat("00", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	api, _ = NewSMAApi(server.URL, Options{TLS: TLSOptions{PinFile: pinFile}})
+	if _, err := api.Login("foo", "bar"); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("Expected error for changed certificate, got %v", err)
+	}
+}
+
+func TestTLSCA(t *testing.T) {
+	server := newTLSServer(tls.NoClientCert)
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	api, err := NewSMAApi(server.URL, Options{TLS: TLSOptions{CAFile: caFile}})
+	if err != nil {
+		t.Fatalf("NewSMAApi returned an error: %v", err)
+	}
+	if _, err := api.Login("foo", "bar"); err != nil {
+		t.Errorf("Login returned an error: %v", err)
+	}
+
+	// with pinning the host name is still verified against the certificate,
+	// which is not issued for localhost
+	pinFile := filepath.Join(t.TempDir(), "known_hosts")
+	api, _ = NewSMAApi(server.URL, Options{TLS: TLSOptions{CAFile: caFile, PinFile: pinFile}})
+	if _, err := api.Login("foo", "bar"); err != nil {
+		t.Errorf("Login returned an error: %v", err)
+	}
+	api, _ = NewSMAApi(strings.Replace(server.URL, "127.0.0.1", "localhost", 1), Options{TLS: TLSOptions{CAFile: caFile, PinFile: pinFile}})
+	if _, err := api.Login("foo", "bar"); err == nil || !strings.Contains(err.Error(), "localhost") {
+		t.Errorf("Expected error for wrong host name, got %v", err)
+	}
+
+	if _, err := NewSMAApi(server.URL, Options{TLS: TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}); err == nil {
+		t.Errorf("Expected error for missing CA bundle")
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	server := newTLSServer(tls.RequireAnyClientCert)
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	opts := TLSOptions{Insecure: true, CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	os.WriteFile(opts.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(opts.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	api, err := NewSMAApi(server.URL, Options{TLS: opts})
+	if err != nil {
+		t.Fatalf("NewSMAApi returned an error: %v", err)
+	}
+	if _, err := api.Login("foo", "bar"); err != nil {
+		t.Errorf("Login returned an error: %v", err)
+	}
+
+	api, _ = NewSMAApi(server.URL, Options{TLS: TLSOptions{Insecure: true}})
+	if _, err := api.Login("foo", "bar"); err == nil {
+		t.Errorf("Expected error without client certificate")
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"result":{"sid":"test-sid","isLogin":false}}`)
+	}))
+	defer server.Close()
+
+	api, err := NewSMAApi(server.URL+"/plant3/sma/", Options{Header: http.Header{"Authorization": {"Bearer token"}}})
+	if err != nil {
+		t.Fatalf("NewSMAApi returned an error: %v", err)
+	}
+	sid, err := api.Login("foo", "bar")
+	if err != nil || sid != "test-sid" {
+		t.Errorf("Login returned %q, %v", sid, err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	if _, err := api.Logout(ctx); err != nil {
+		t.Errorf("Logout returned an error: %v", err)
+	}
+
+	expected := []string{"/plant3/sma/dyn/login.json", "/plant3/sma/dyn/logout.json"}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected requests %v, but got %v", expected, requests)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// requests through a proxy carry the absolute URL
+		requested = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"result":{"sid":"test-sid"}}`)
+	}))
+	defer proxy.Close()
+
+	api, err := NewSMAApi("http://sma733147246.invalid/plant3", Options{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewSMAApi returned an error: %v", err)
+	}
+	if _, err := api.Login("foo", "bar"); err != nil {
+		t.Errorf("Login returned an error: %v", err)
+	}
+	if requested != "http://sma733147246.invalid/plant3/dyn/login.json" {
+		t.Errorf("Invalid proxied request: %q", requested)
+	}
+
+	if _, err := NewSMAApi("http://sma733147246.invalid", Options{Proxy: "ftp://proxy"}); err == nil {
+		t.Errorf("Expected error for unsupported proxy scheme")
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	server, api := setupTest(`{"result":{"isLogin":false}}`)
+	defer server.Close()
+	var buf bytes.Buffer
+	api.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), "0123456789abcdef")
+	if _, err := api.Logout(ctx); err != nil {
+		t.Fatalf("Logout returned an error: %v", err)
+	}
+	var record struct {
+		Level    string `json:"level"`
+		Msg      string `json:"msg"`
+		Endpoint string `json:"endpoint"`
+		Status   int    `json:"status"`
+		SID      string `json:"sid"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("error parsing log record %q: %v", buf.String(), err)
+	}
+	if record.Level != "DEBUG" || record.Endpoint != "/dyn/logout.json" || record.Status != http.StatusOK || record.SID != "0123***" {
+		t.Errorf("Invalid log record: %+v", record)
+	}
+
+	// failed requests must not leak the session ID either
+	buf.Reset()
+	server.Close()
+	_, err := api.Logout(ctx)
+	if err == nil {
+		t.Fatalf("Expected error for closed server")
+	}
+	if strings.Contains(err.Error(), "0123456789abcdef") || strings.Contains(buf.String(), "0123456789abcdef") {
+		t.Errorf("Session ID not redacted: %v, %s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), `"level":"WARN"`) {
+		t.Errorf("Expected warning, got %s", buf.String())
+	}
+}
+
+func TestMockSessions(t *testing.T) {
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", fstest.MapFS{"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}))
+	mock.MaxSessions = 2
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+
+	var apiErr *APIError
+	if _, err := api.Login("usr", "wrong"); !errors.As(err, &apiErr) || apiErr.Code != 401 {
+		t.Errorf("Expected error 401 for wrong password, got %v", err)
+	}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	if _, err := api.Login("istl", "secret"); err != nil {
+		t.Errorf("Login returned an error: %v", err)
+	}
+	if _, err := api.Login("usr", "secret"); !errors.As(err, &apiErr) || apiErr.Code != 503 {
+		t.Errorf("Expected error 503 for too many sessions, got %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+	if _, err := api.GetFS(ctx, "/DIAGNOSE"); err != nil {
+		t.Errorf("GetFS returned an error: %v", err)
+	}
+	if content, err := api.Download(ctx, "/DIAGNOSE/file1.txt"); err != nil || string(content) != "file1.txt content\n" {
+		t.Errorf("Download returned %q, %v", content, err)
+	}
+	if _, err := api.Logout(ctx); err != nil {
+		t.Errorf("Logout returned an error: %v", err)
+	}
+	if mock.Sessions() != 1 {
+		t.Errorf("Expected 1 session after logout, got %d", mock.Sessions())
+	}
+	if _, err := api.GetFS(ctx, "/DIAGNOSE"); !errors.As(err, &apiErr) || apiErr.Code != 401 {
+		t.Errorf("Expected error 401 after logout, got %v", err)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", fstest.MapFS{"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+
+	// every endpoint renews the session of the context
+	for name, f := range map[string]func() error{
+		"GetFS":     func() error { _, err := api.GetFS(ctx, "/DIAGNOSE"); return err },
+		"GetValues": func() error { _, err := api.GetValues(ctx, DefaultKeys); return err },
+		"GetLogger": func() error { _, err := api.GetLogger(ctx, LoggerKey5Min, time.Unix(0, 0), time.Now()); return err },
+		"Download":  func() error { _, err := api.Download(ctx, "/DIAGNOSE/file1.txt"); return err },
+	} {
+		mock.ExpireSessions()
+		if err := f(); err != nil {
+			t.Errorf("%s returned an error after session expiry: %v", name, err)
+		}
+	}
+
+	// concurrent requests with the expired session share one renewal
+	mock.ExpireSessions()
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := api.GetFS(ctx, "/DIAGNOSE"); err != nil {
+				t.Errorf("GetFS returned an error after session expiry: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if mock.Sessions() != 1 {
+		t.Errorf("Expected 1 session, got %d", mock.Sessions())
+	}
+
+	// logging out ends the renewed session
+	if _, err := api.Logout(ctx); err != nil {
+		t.Errorf("Logout returned an error: %v", err)
+	}
+	if mock.Sessions() != 0 {
+		t.Errorf("Expected no session after logout, got %d", mock.Sessions())
+	}
+}
+
+func TestMockDevices(t *testing.T) {
+	inverter := tests.NewMockDevice("1901234567", fstest.MapFS{})
+	inverter.Logger[LoggerKeyDaily] = []types.LoggerEntry{{Timestamp: 1700000000, Value: new(float64)}, {Timestamp: 1800000000}}
+	battery := tests.NewMockDevice("3012345678", fstest.MapFS{})
+	battery.Values = map[string]map[string]interface{}{"6100_40263F00": {"1": -500}}
+	server := httptest.NewServer(tests.NewSMAMock("secret", inverter, battery))
+	defer server.Close()
+
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+
+	samples, err := api.GetValues(ctx, []string{"6100_40263F00"})
+	if err != nil {
+		t.Fatalf("GetValues returned an error: %v", err)
+	}
+	if len(samples) != 2 || samples[0].Device != "1901234567" || samples[0].Value != 2340 || samples[1].Device != "3012345678" || samples[1].Value != -500 {
+		t.Errorf("Invalid samples: %+v", samples)
+	}
+
+	samples, err = api.GetLogger(ctx, LoggerKeyDaily, time.Unix(1600000000, 0), time.Unix(1750000000, 0))
+	if err != nil {
+		t.Fatalf("GetLogger returned an error: %v", err)
+	}
+	if len(samples) != 1 || samples[0].Device != "1901234567" {
+		t.Errorf("Invalid samples: %+v", samples)
+	}
+
+	// the listing of several devices is not supported
+	if _, err := api.GetFS(ctx, "/"); err == nil {
+		t.Errorf("Expected error for multiple devices")
+	}
+}
+
+func TestMockFaults(t *testing.T) {
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", fstest.MapFS{"file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+
+	for _, spec := range []string{"/dyn/getFS.json=500*1", "/dyn/getFS.json=drop*1", "/dyn/getFS.json=truncate*1"} {
+		fault, err := tests.ParseFault(spec)
+		if err != nil {
+			t.Fatalf("ParseFault returned an error: %v", err)
+		}
+		mock.AddFault(fault)
+		if _, err := api.GetFS(ctx, "/"); err == nil {
+			t.Errorf("Expected error for fault %s", spec)
+		}
+		// the fault is used up
+		if _, err := api.GetFS(ctx, "/"); err != nil {
+			t.Errorf("GetFS returned an error after fault %s: %v", spec, err)
+		}
+	}
+
+	mock.AddFault(tests.Fault{Path: "/fs/", Truncate: true})
+	if _, err := api.Download(ctx, "/file1.txt"); err == nil {
+		t.Errorf("Expected error for truncated download")
+	}
+	mock.ClearFaults()
+
+	if _, err := tests.ParseFault("/dyn/getFS.json=explode"); err == nil {
+		t.Errorf("Expected error for invalid fault")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	mock := tests.NewSMAMock("secret", tests.NewMockDevice("1901234567", fstest.MapFS{"file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}))
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	api := SMAApi{Base: server.URL, Client: *http.DefaultClient}
+	sid, err := api.Login("usr", "secret")
+	if err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+
+	// Logout goes last, the mock still ends the session after the delay
+	for _, c := range []struct {
+		name string
+		op   func(ctx context.Context) error
+	}{
+		{"GetFS", func(ctx context.Context) error { _, err := api.GetFS(ctx, "/"); return err }},
+		{"GetLogger", func(ctx context.Context) error {
+			_, err := api.GetLogger(ctx, 28672, time.Unix(0, 0), time.Now())
+			return err
+		}},
+		{"GetValues", func(ctx context.Context) error { _, err := api.GetValues(ctx, nil); return err }},
+		{"Download", func(ctx context.Context) error { _, err := api.Download(ctx, "/file1.txt"); return err }},
+		{"Logout", func(ctx context.Context) error { _, err := api.Logout(ctx); return err }},
+	} {
+		mock.AddFault(tests.Fault{Delay: 500 * time.Millisecond, Count: 1})
+		ctx := context.WithValue(context.Background(), types.ApiContextKey("sid"), sid)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		start := time.Now()
+		err := c.op(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected context.DeadlineExceeded, got %v", c.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+			t.Errorf("%s: returned after %v, expected to be canceled", c.name, elapsed)
+		}
+		mock.ClearFaults()
+	}
+}
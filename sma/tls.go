@@ -0,0 +1,176 @@
+package sma
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TLSOptions configure how the certificate of an inverter is verified and
+// which client certificate is presented. Without options the system roots
+// are used.
+type TLSOptions struct {
+	// Insecure skips the verification
+	Insecure bool
+	// CAFile is a PEM bundle of the certificate authorities to trust
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key
+	CertFile, KeyFile string
+	// PinFile stores the fingerprints of the inverter certificates. If set,
+	// the certificate of an unknown inverter is trusted on first use and
+	// pinned, later connections must present the same certificate.
+	PinFile string
+}
+
+// Config returns the TLS configuration for the inverter at host.
+func (o TLSOptions) Config(host string) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("error no certificates in CA bundle %s", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.PinFile != "" && !o.Insecure {
+		pins := &PinStore{Path: o.PinFile}
+		// the chain of self-signed certificates can not be verified, the
+		// pin replaces the verification unless a CA is given
+		verifyChain := o.CAFile != ""
+		roots := cfg.RootCAs
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("error no certificate presented by %s", host)
+			}
+			if verifyChain {
+				intermediates := x509.NewCertPool()
+				for _, cert := range cs.PeerCertificates[1:] {
+					intermediates.AddCert(cert)
+				}
+				if _, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: cs.ServerName, Roots: roots, Intermediates: intermediates}); err != nil {
+					return err
+				}
+			}
+			return pins.Check(host, Fingerprint(cs.PeerCertificates[0]))
+		}
+	}
+	return cfg, nil
+}
+
+// NewTransport returns a transport of its own with the TLS configuration
+// of o for host. Other settings are those of http.DefaultTransport.
+func (o TLSOptions) NewTransport(host string) (*http.Transport, error) {
+	cfg, err := o.Config(host)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return transport, nil
+}
+
+// Fingerprint returns the hex encoded SHA-256 of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultPinFile returns the pin file in the user's configuration
+// directory.
+func DefaultPinFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-smafs", "known_hosts")
+}
+
+// PinStore is a file with a line "<host> <fingerprint>" per pinned
+// certificate.
+type PinStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// PinMismatchError is returned if an inverter presents a certificate other
+// than the pinned one.
+type PinMismatchError struct {
+	Host, Pinned, Presented string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("error certificate of %s changed: presented %s, pinned %s; remove the line from the pin file if the change is expected", e.Host, e.Presented, e.Pinned)
+}
+
+// Check compares fingerprint to the pin of host, pinning it if host is
+// unknown.
+func (s *PinStore) Check(host, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pinned, err := s.lookup(host)
+	if err != nil {
+		return err
+	}
+	if pinned == "" {
+		return s.add(host, fingerprint)
+	}
+	if pinned != fingerprint {
+		return &PinMismatchError{Host: host, Pinned: pinned, Presented: fingerprint}
+	}
+	return nil
+}
+
+func (s *PinStore) lookup(host string) (string, error) {
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error reading pin file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == host {
+			return strings.ToLower(fields[1]), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+func (s *PinStore) add(host, fingerprint string) error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("error creating pin file: %v", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing pin file: %v", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", host, fingerprint); err != nil {
+		return fmt.Errorf("error writing pin file: %v", err)
+	}
+	return nil
+}
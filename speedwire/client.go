@@ -0,0 +1,343 @@
+package speedwire
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
+	"github.com/dominikbayerl/go-smafs/values"
+)
+
+// User groups to log in with
+const (
+	UserGroupUser      = 0x07
+	UserGroupInstaller = 0x0a
+)
+
+const (
+	cmdLogin  = 0xfffd040c
+	cmdLogoff = 0xfffd010e
+)
+
+// loginTimeout is the session lifetime requested on login, in seconds
+const loginTimeout = 900
+
+// Query is a data query for the records of the LRIs between First and Last.
+type Query struct {
+	Command     uint32
+	First, Last uint32
+}
+
+// Queries of the commonly available spot values, yields and device status.
+var (
+	QuerySpotACPower   = Query{0x51000200, 0x00263f00, 0x00263fff}
+	QuerySpotACVoltage = Query{0x51000200, 0x00464800, 0x004655ff}
+	QuerySpotFrequency = Query{0x51000200, 0x00465700, 0x004657ff}
+	QuerySpotDCPower   = Query{0x53800200, 0x00251e00, 0x00251eff}
+	QuerySpotDCVoltage = Query{0x53800200, 0x00451f00, 0x004521ff}
+	QueryYield         = Query{0x54000200, 0x00260100, 0x002622ff}
+	QueryStatus        = Query{0x51800200, 0x00214800, 0x002148ff}
+)
+
+// DefaultQueries are the queries of Values, matching sma.DefaultKeys.
+var DefaultQueries = []Query{QuerySpotACPower, QueryYield, QuerySpotDCPower, QueryStatus}
+
+// Client talks to a single device using the Speedwire protocol.
+type Client struct {
+	conn    *net.UDPConn
+	device  Address
+	timeout time.Duration
+	queries []Query
+
+	mu       sync.Mutex
+	id       uint16
+	group    uint32
+	password string
+}
+
+// Dial connects to the device at host, with or without port, and asks for
+// its address. Replies not received within timeout fail the request.
+func Dial(host string, timeout time.Duration) (*Client, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(Port))
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", host, err)
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket: %v", err)
+	}
+	c := &Client{conn: conn, device: Broadcast, timeout: timeout, queries: DefaultQueries}
+
+	resp, err := c.request(Packet{Control: 0xa0, Command: cmdIdentify, Data: make([]byte, 8)})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error identifying device: %v", err)
+	}
+	c.device = Address{SUSyID: resp.Src.SUSyID, Serial: resp.Src.Serial}
+	return c, nil
+}
+
+// Device returns the address of the connected device.
+func (c *Client) Device() Address {
+	return c.device
+}
+
+// SetQueries replaces the queries of Values.
+func (c *Client) SetQueries(queries []Query) {
+	c.queries = queries
+}
+
+// request sends p to the device and waits for the response with the same
+// packet ID.
+func (c *Client) request(p Packet) (Packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.id = (c.id + 1) & 0x7fff
+	if p.Dst == (Address{}) {
+		p.Dst = c.device
+	}
+	p.Src = AppAddress
+	p.Src.Control = p.Dst.Control
+	p.PacketID = 0x8000 | c.id
+	if _, err := c.conn.Write(p.Marshal()); err != nil {
+		return Packet{}, fmt.Errorf("error sending request: %v", err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, err := c.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return Packet{}, fmt.Errorf("error no response to command %#08x", p.Command)
+		} else if err != nil {
+			return Packet{}, fmt.Errorf("error receiving response: %v", err)
+		}
+		resp, err := Unmarshal(buf[:n])
+		if err != nil || resp.Dst.Serial != AppAddress.Serial || resp.PacketID&0x7fff != c.id {
+			continue
+		}
+		resp.Data = append([]byte{}, resp.Data...)
+		return resp, nil
+	}
+}
+
+// Login opens a session in the user group with password. The session is
+// renewed by Values if it expired.
+func (c *Client) Login(group uint32, password string) error {
+	if len(password) > 12 {
+		return fmt.Errorf("error password longer than 12 characters")
+	}
+	data := make([]byte, 16, 28)
+	binary.LittleEndian.PutUint32(data, group)
+	binary.LittleEndian.PutUint32(data[4:], loginTimeout)
+	binary.LittleEndian.PutUint32(data[8:], uint32(time.Now().Unix()))
+	// the password is padded to 12 bytes and obfuscated per user group
+	enc := byte(0x88)
+	if group == UserGroupInstaller {
+		enc = 0xbb
+	}
+	for idx := 0; idx < 12; idx++ {
+		var ch byte
+		if idx < len(password) {
+			ch = password[idx]
+		}
+		data = append(data, ch+enc)
+	}
+
+	resp, err := c.request(Packet{Control: 0xa0, Dst: Address{SUSyID: Broadcast.SUSyID, Serial: Broadcast.Serial, Control: 0x0100}, Command: cmdLogin, Data: data})
+	if err != nil {
+		return err
+	}
+	if resp.ErrorCode == 0x0100 {
+		return fmt.Errorf("error login rejected: invalid password")
+	} else if resp.ErrorCode != 0 {
+		return fmt.Errorf("error login rejected with code %#04x", resp.ErrorCode)
+	}
+
+	c.mu.Lock()
+	c.group, c.password = group, password
+	c.mu.Unlock()
+	return nil
+}
+
+// Logoff closes the session and the connection.
+func (c *Client) Logoff() error {
+	defer c.conn.Close()
+	p := Packet{
+		Control: 0xa0,
+		Dst:     Address{SUSyID: Broadcast.SUSyID, Serial: Broadcast.Serial, Control: 0x0300},
+		Src:     Address{SUSyID: AppAddress.SUSyID, Serial: AppAddress.Serial, Control: 0x0300},
+		Command: cmdLogoff,
+		Data:    []byte{0xff, 0xff, 0xff, 0xff},
+	}
+	c.mu.Lock()
+	c.id = (c.id + 1) & 0x7fff
+	p.PacketID = 0x8000 | c.id
+	c.mu.Unlock()
+	if _, err := c.conn.Write(p.Marshal()); err != nil {
+		return fmt.Errorf("error sending logoff: %v", err)
+	}
+	return nil
+}
+
+// Query returns the values of the records answering q. Known values are
+// scaled and named as the keys in sma.Channels.
+func (c *Client) Query(q Query) ([]types.Sample, error) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, q.First)
+	binary.LittleEndian.PutUint32(data[4:], q.Last)
+	resp, err := c.request(Packet{Control: 0xa0, Command: q.Command, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != 0 {
+		return nil, &QueryError{Command: q.Command, Code: resp.ErrorCode}
+	}
+	return decodeRecords(q.Command, strconv.FormatUint(uint64(resp.Src.Serial), 10), resp.Data, time.Now())
+}
+
+// QueryError is returned for queries rejected by the device, e.g. because
+// the session expired.
+type QueryError struct {
+	Command uint32
+	Code    uint16
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("error command %#08x rejected with code %#04x", e.Command, e.Code)
+}
+
+var _ = (values.Source)((*Client)(nil))
+
+// Values returns the results of the configured queries, DefaultQueries
+// unless changed by SetQueries. Rejected queries are retried once after
+// logging in again.
+func (c *Client) Values(ctx context.Context) ([]types.Sample, error) {
+	var samples []types.Sample
+	relogin := true
+	for _, q := range c.queries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, err := c.Query(q)
+		var queryErr *QueryError
+		if errors.As(err, &queryErr) && relogin {
+			relogin = false
+			c.mu.Lock()
+			group, password := c.group, c.password
+			c.mu.Unlock()
+			if err := c.Login(group, password); err != nil {
+				return nil, err
+			}
+			s, err = c.Query(q)
+		}
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s...)
+	}
+	return samples, nil
+}
+
+// decodeRecords parses the records of a response to command. Each record
+// starts with the class, LRI and data type, followed by its timestamp and
+// values. NaN values are skipped.
+func decodeRecords(command uint32, device string, data []byte, now time.Time) ([]types.Sample, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("error truncated response to command %#08x", command)
+	}
+	first := binary.LittleEndian.Uint32(data)
+	last := binary.LittleEndian.Uint32(data[4:])
+	records := data[8:]
+	if last < first || int(last-first+1) > len(records)/8 {
+		return nil, fmt.Errorf("error invalid record range %d-%d", first, last)
+	}
+	size := len(records) / int(last-first+1)
+
+	// LRIs with several records, like the DC inputs, get the class appended
+	count := make(map[uint32]int)
+	for off := 0; off+size <= len(records); off += size {
+		count[binary.LittleEndian.Uint32(records[off:])&0x00ffff00]++
+	}
+
+	var samples []types.Sample
+	for off := 0; off+size <= len(records); off += size {
+		record := records[off : off+size]
+		code := binary.LittleEndian.Uint32(record)
+		class, lri, dataType := code&0xff, (code>>8)&0xffff, byte(code>>24)
+		if code == 0 {
+			continue
+		}
+		t := now
+		if ts := binary.LittleEndian.Uint32(record[4:]); ts != 0 {
+			t = time.Unix(int64(ts), 0)
+		}
+
+		v, ok := recordValue(record, dataType)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%04X_%02X%04X00", command>>16+0x1000, dataType, lri)
+		channel, ok := sma.Channels[key]
+		if !ok {
+			channel = sma.Channel{Name: key, Scale: 1}
+		}
+		name := channel.Name
+		if count[code&0x00ffff00] > 1 {
+			name = fmt.Sprintf("%s[%d]", name, class)
+		}
+		samples = append(samples, types.Sample{Device: device, Channel: name, Unit: channel.Unit, Time: t, Value: v * channel.Scale})
+	}
+	return samples, nil
+}
+
+// recordValue returns the first value of record, false if it is NaN.
+func recordValue(record []byte, dataType byte) (float64, bool) {
+	values := record[8:]
+	switch {
+	case len(values) < 4:
+		return 0, false
+	case dataType == 0x08:
+		// status records list tags, the active one has the highest bit set
+		for off := 0; off+4 <= len(values); off += 4 {
+			tag := binary.LittleEndian.Uint32(values[off:])
+			if tag == 0x00fffffe {
+				break
+			}
+			if tag>>24 == 0x01 {
+				return float64(tag & 0x00ffffff), true
+			}
+		}
+		return 0, false
+	case len(record) == 16:
+		v := binary.LittleEndian.Uint64(values)
+		if v == 0xffffffffffffffff || v == 0x8000000000000000 {
+			return 0, false
+		}
+		return float64(v), true
+	case dataType == 0x40:
+		v := binary.LittleEndian.Uint32(values)
+		if v == 0x80000000 {
+			return 0, false
+		}
+		return float64(int32(v)), true
+	default:
+		v := binary.LittleEndian.Uint32(values)
+		if v == 0xffffffff || v == 0x80000000 {
+			return 0, false
+		}
+		return float64(v), true
+	}
+}
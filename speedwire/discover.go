@@ -0,0 +1,239 @@
+package speedwire
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// MDNSAddr is the multicast group of mDNS responders.
+const MDNSAddr = "224.0.0.251:5353"
+
+// cmdIdentify requests the SUSyID and serial of all devices addressed
+const cmdIdentify = 0x00000200
+
+// Device is a device found on the LAN.
+type Device struct {
+	Addr   *net.UDPAddr
+	Host   string
+	SUSyID uint16
+	Serial uint32
+}
+
+// URL returns the address of the web interface of d.
+func (d Device) URL() string {
+	if d.Host != "" {
+		return "https://" + d.Host + "/"
+	}
+	return "https://" + d.Addr.IP.String() + "/"
+}
+
+// Hostname returns the default host name of the device with serial.
+func Hostname(serial uint32) string {
+	return fmt.Sprintf("SMA%d", serial)
+}
+
+// identifyRequest asks the device at a unicast address for its SUSyID and
+// serial.
+func identifyRequest(id uint16) []byte {
+	return Packet{
+		Control:  0xa0,
+		Dst:      Broadcast,
+		Src:      AppAddress,
+		PacketID: 0x8000 | id,
+		Command:  cmdIdentify,
+		Data:     make([]byte, 8),
+	}.Marshal()
+}
+
+// Discover sends the discovery request to addr, usually MulticastAddr, and
+// collects the devices answering within timeout. Each device is asked for its
+// SUSyID and serial number. Devices that do not tell are still returned.
+func Discover(ctx context.Context, addr string, timeout time.Duration) ([]Device, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", addr, err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	if _, err := conn.WriteToUDP(discoveryRequest, raddr); err != nil {
+		return nil, fmt.Errorf("error sending discovery request: %v", err)
+	}
+
+	var devices []Device
+	seen := make(map[string]int)
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return devices, nil
+		} else if err != nil {
+			return devices, fmt.Errorf("error receiving responses: %v", err)
+		}
+		t, err := tags(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// discovery responses carry a tag 0x0010 of another protocol
+		if data := t[tagData]; len(data) >= 2 && binary.BigEndian.Uint16(data) == protocolData {
+			p, err := Unmarshal(buf[:n])
+			if err != nil || p.Dst.Serial != AppAddress.Serial {
+				continue
+			}
+			if idx, ok := seen[from.String()]; ok && int(p.PacketID&0x7fff) == idx {
+				devices[idx].SUSyID = p.Src.SUSyID
+				devices[idx].Serial = p.Src.Serial
+			}
+			continue
+		}
+
+		// the request itself may be looped back
+		if group := t[tagGroup]; len(group) != 4 || binary.BigEndian.Uint32(group) == 0xffffffff {
+			continue
+		}
+		device := &net.UDPAddr{IP: from.IP, Port: from.Port}
+		if ip := t[tagIP]; len(ip) == 4 {
+			device.IP = net.IP(append([]byte{}, ip...))
+		}
+		if _, ok := seen[device.String()]; ok {
+			continue
+		}
+		seen[device.String()] = len(devices)
+		devices = append(devices, Device{Addr: device})
+		if _, err := conn.WriteToUDP(identifyRequest(uint16(len(devices)-1)), device); err != nil {
+			return devices, fmt.Errorf("error sending identify request: %v", err)
+		}
+	}
+}
+
+// Resolve looks up the device with serial by its default host name, first
+// using mDNS and then the system resolver. This finds devices that have
+// Speedwire disabled.
+func Resolve(ctx context.Context, serial uint32, timeout time.Duration) (Device, error) {
+	host := Hostname(serial)
+	device := Device{Serial: serial}
+	if ip, err := LookupMDNS(ctx, host+".local", MDNSAddr, timeout); err == nil {
+		device.Addr = &net.UDPAddr{IP: ip, Port: Port}
+		device.Host = host + ".local"
+		return device, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return device, fmt.Errorf("error resolving %s: %v", host, err)
+	}
+	device.Addr = &net.UDPAddr{IP: addrs[0].IP, Port: Port}
+	device.Host = strings.ToLower(host)
+	return device, nil
+}
+
+// LookupMDNS queries the IPv4 address of host from the mDNS responders at
+// addr, usually MDNSAddr.
+func LookupMDNS(ctx context.Context, host, addr string, timeout time.Duration) (net.IP, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", addr, err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	if _, err := conn.WriteToUDP(mdnsQuery(host), raddr); err != nil {
+		return nil, fmt.Errorf("error sending mDNS query: %v", err)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("error receiving mDNS response for %s: %v", host, err)
+		}
+		if ip := mdnsAnswer(buf[:n]); ip != nil {
+			return ip, nil
+		}
+	}
+}
+
+// mdnsQuery returns a query for the A record of host, asking for a unicast
+// response.
+func mdnsQuery(host string) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	// type A, class IN with unicast response bit
+	return append(b, 0x00, 0x00, 0x01, 0x80, 0x01)
+}
+
+// mdnsAnswer returns the address of the first A record in the response msg.
+func mdnsAnswer(msg []byte) net.IP {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	i := 12
+	for ; questions > 0; questions-- {
+		i = skipName(msg, i) + 4
+	}
+	for ; answers > 0; answers-- {
+		i = skipName(msg, i)
+		if i+10 > len(msg) {
+			return nil
+		}
+		typ := binary.BigEndian.Uint16(msg[i:])
+		length := int(binary.BigEndian.Uint16(msg[i+8:]))
+		i += 10
+		if i+length > len(msg) {
+			return nil
+		}
+		if typ == 1 && length == 4 {
+			return net.IP(append([]byte{}, msg[i:i+4]...))
+		}
+		i += length
+	}
+	return nil
+}
+
+// skipName returns the offset after the domain name starting at i.
+func skipName(msg []byte, i int) int {
+	for i < len(msg) {
+		length := int(msg[i])
+		if length == 0 {
+			return i + 1
+		} else if length&0xc0 == 0xc0 {
+			return i + 2
+		}
+		i += 1 + length
+	}
+	return len(msg)
+}
@@ -0,0 +1,164 @@
+package speedwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Port is the UDP port of Speedwire devices, MulticastAddr the group they
+// listen on for discovery requests.
+const (
+	Port          = 9522
+	MulticastAddr = "239.12.255.254:9522"
+)
+
+// tags of the Speedwire frame, each prefixed by its length
+const (
+	tagEnd       = 0x0000
+	tagData      = 0x0010
+	tagDiscovery = 0x0020
+	tagIP        = 0x0030
+	tagGroup     = 0x02a0
+)
+
+// protocolData is the protocol ID of SMA Net 2 data packets.
+const protocolData = 0x6065
+
+// signature starts every Speedwire datagram
+var signature = []byte("SMA\x00")
+
+// discoveryRequest is sent to MulticastAddr, all devices answer it.
+var discoveryRequest = []byte{
+	'S', 'M', 'A', 0x00,
+	0x00, 0x04, 0x02, 0xa0, 0xff, 0xff, 0xff, 0xff,
+	0x00, 0x00, 0x00, 0x20,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// Address identifies a device by its SUSyID and serial number. Control is
+// the job number of the device, 0 for inverters.
+type Address struct {
+	SUSyID  uint16
+	Serial  uint32
+	Control uint16
+}
+
+// Broadcast addresses all devices.
+var Broadcast = Address{SUSyID: 0xffff, Serial: 0xffffffff}
+
+// AppAddress is the source address of this program. The serial is random
+// so that several instances do not get each other's responses.
+var AppAddress = Address{SUSyID: 125, Serial: 900000000 + uint32(rand.New(rand.NewSource(time.Now().UnixNano())).Int31n(100000000))}
+
+// Packet is an SMA Net 2 data packet.
+type Packet struct {
+	Control   byte
+	Dst       Address
+	Src       Address
+	ErrorCode uint16
+	Fragment  uint16
+	PacketID  uint16
+	Command   uint32
+	Data      []byte
+}
+
+// Marshal returns the Speedwire datagram carrying p.
+func (p Packet) Marshal() []byte {
+	data := make([]byte, 0, 36+len(p.Data))
+	data = append(data, 0, p.Control)
+	data = appendAddress(data, p.Dst)
+	data = appendAddress(data, p.Src)
+	data = appendUint16(data, binary.LittleEndian, p.ErrorCode)
+	data = appendUint16(data, binary.LittleEndian, p.Fragment)
+	data = appendUint16(data, binary.LittleEndian, p.PacketID)
+	data = appendUint32(data, binary.LittleEndian, p.Command)
+	data = append(data, p.Data...)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	data[0] = byte(len(data) / 4)
+
+	b := append([]byte{}, signature...)
+	b = append(b, 0x00, 0x04, 0x02, 0xa0, 0x00, 0x00, 0x00, 0x01)
+	b = appendUint16(b, binary.BigEndian, uint16(2+len(data)))
+	b = appendUint16(b, binary.BigEndian, tagData)
+	b = appendUint16(b, binary.BigEndian, protocolData)
+	b = append(b, data...)
+	return append(b, 0x00, 0x00, 0x00, 0x00)
+}
+
+func appendAddress(b []byte, a Address) []byte {
+	b = appendUint16(b, binary.LittleEndian, a.SUSyID)
+	b = appendUint32(b, binary.LittleEndian, a.Serial)
+	return appendUint16(b, binary.LittleEndian, a.Control)
+}
+
+func readAddress(b []byte) Address {
+	return Address{
+		SUSyID:  binary.LittleEndian.Uint16(b),
+		Serial:  binary.LittleEndian.Uint32(b[2:]),
+		Control: binary.LittleEndian.Uint16(b[6:]),
+	}
+}
+
+// tags returns the payloads of the tags in datagram b, by tag.
+func tags(b []byte) (map[uint16][]byte, error) {
+	if !bytes.HasPrefix(b, signature) {
+		return nil, fmt.Errorf("error missing Speedwire signature")
+	}
+	result := make(map[uint16][]byte)
+	for b = b[len(signature):]; len(b) >= 4; {
+		length := int(binary.BigEndian.Uint16(b))
+		tag := binary.BigEndian.Uint16(b[2:])
+		if tag == tagEnd && length == 0 {
+			break
+		}
+		if len(b) < 4+length {
+			return nil, fmt.Errorf("error truncated Speedwire tag %#04x", tag)
+		}
+		result[tag] = b[4 : 4+length]
+		b = b[4+length:]
+	}
+	return result, nil
+}
+
+// Unmarshal parses the SMA Net 2 packet in datagram b.
+func Unmarshal(b []byte) (Packet, error) {
+	t, err := tags(b)
+	if err != nil {
+		return Packet{}, err
+	}
+	data, ok := t[tagData]
+	if !ok || len(data) < 2 || binary.BigEndian.Uint16(data) != protocolData {
+		return Packet{}, fmt.Errorf("error no SMA Net 2 packet")
+	}
+	data = data[2:]
+	if len(data) < 28 {
+		return Packet{}, fmt.Errorf("error truncated SMA Net 2 packet")
+	}
+	return Packet{
+		Control:   data[1],
+		Dst:       readAddress(data[2:]),
+		Src:       readAddress(data[10:]),
+		ErrorCode: binary.LittleEndian.Uint16(data[18:]),
+		Fragment:  binary.LittleEndian.Uint16(data[20:]),
+		PacketID:  binary.LittleEndian.Uint16(data[22:]),
+		Command:   binary.LittleEndian.Uint32(data[24:]),
+		Data:      data[28:],
+	}, nil
+}
+
+func appendUint16(b []byte, order binary.ByteOrder, v uint16) []byte {
+	var buf [2]byte
+	order.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, order binary.ByteOrder, v uint32) []byte {
+	var buf [4]byte
+	order.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,229 @@
+package speedwire
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/tests"
+)
+
+func TestPacket(t *testing.T) {
+	p := Packet{
+		Control:  0xa0,
+		Dst:      Broadcast,
+		Src:      Address{SUSyID: 125, Serial: 0x3a28be52},
+		PacketID: 0x8001,
+		Command:  cmdIdentify,
+		Data:     make([]byte, 8),
+	}
+	b := p.Marshal()
+	// identify request as sent by SBFspot
+	expected := []byte{
+		0x53, 0x4d, 0x41, 0x00, 0x00, 0x04, 0x02, 0xa0, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x26, 0x00, 0x10, 0x60, 0x65, 0x09, 0xa0, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0x00, 0x00, 0x7d, 0x00, 0x52, 0xbe, 0x28, 0x3a, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x80, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Invalid packet:\n%x\nexpected:\n%x", b, expected)
+	}
+
+	parsed, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal returned an error: %v", err)
+	}
+	if parsed.Src != p.Src || parsed.Dst != p.Dst || parsed.PacketID != p.PacketID || parsed.Command != p.Command || !bytes.Equal(parsed.Data, p.Data) {
+		t.Errorf("Invalid parsed packet: %+v", parsed)
+	}
+
+	if _, err := Unmarshal(b[:30]); err == nil {
+		t.Errorf("Expected error for truncated packet")
+	}
+	if _, err := Unmarshal(discoveryRequest); err == nil {
+		t.Errorf("Expected error for discovery request")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	device, err := tests.NewSpeedwireDevice(0x8a, 733147246)
+	if err != nil {
+		t.Fatalf("error starting Speedwire device: %v", err)
+	}
+	defer device.Close()
+
+	devices, err := Discover(context.Background(), device.Addr(), 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Discover returned an error: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	d := devices[0]
+	if d.Addr.String() != device.Addr() {
+		t.Errorf("Invalid address: %v", d.Addr)
+	}
+	if d.SUSyID != 0x8a || d.Serial != 733147246 {
+		t.Errorf("Invalid SUSyID %d or serial %d", d.SUSyID, d.Serial)
+	}
+	if d.URL() != "https://127.0.0.1/" {
+		t.Errorf("Invalid URL: %s", d.URL())
+	}
+	if Hostname(d.Serial) != "SMA733147246" {
+		t.Errorf("Invalid host name: %s", Hostname(d.Serial))
+	}
+}
+
+func TestDiscoverNone(t *testing.T) {
+	// nobody listens on the address of a closed socket
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error opening socket: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	devices, err := Discover(context.Background(), addr, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Discover returned an error: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("Expected no devices, got %v", devices)
+	}
+}
+
+func TestLookupMDNS(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error opening socket: %v", err)
+	}
+	defer conn.Close()
+
+	// responder answering with a compressed name pointing to the question
+	go func() {
+		buf := make([]byte, 1500)
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		resp := append([]byte{}, buf[:n]...)
+		resp[2] = 0x84
+		resp[7] = 1
+		resp = append(resp, 0xc0, 0x0c, 0x00, 0x01, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x04, 192, 168, 178, 22)
+		conn.WriteToUDP(resp, from)
+	}()
+
+	ip, err := LookupMDNS(context.Background(), "SMA733147246.local", conn.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("LookupMDNS returned an error: %v", err)
+	}
+	if !ip.Equal(net.IPv4(192, 168, 178, 22)) {
+		t.Errorf("Invalid address: %v", ip)
+	}
+}
+
+// u32s encodes values as the values of a record
+func u32s(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for idx, v := range values {
+		binary.LittleEndian.PutUint32(b[4*idx:], v)
+	}
+	return b
+}
+
+func setupDevice(t *testing.T) *tests.SpeedwireDevice {
+	device, err := tests.NewSpeedwireDevice(0x8a, 733147246)
+	if err != nil {
+		t.Fatalf("error starting Speedwire device: %v", err)
+	}
+	device.SetPassword("0000")
+	ts := uint32(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC).Unix())
+	nan := uint32(0x80000000)
+	for _, r := range []tests.SpeedwireRecord{
+		{Command: 0x51000200, Code: 0x40263f01, Time: ts, Values: u32s(1234, nan, nan, nan, 1)},
+		{Command: 0x51000200, Code: 0x00465701, Time: ts, Values: u32s(5001, 0xffffffff, 0xffffffff, 0xffffffff, 1)},
+		{Command: 0x53800200, Code: 0x40251e01, Time: ts, Values: u32s(600, nan, nan, nan, 1)},
+		{Command: 0x53800200, Code: 0x40251e02, Time: ts, Values: u32s(nan, nan, nan, nan, 1)},
+		{Command: 0x54000200, Code: 0x00260101, Time: ts, Values: u32s(5000000, 0)},
+		{Command: 0x54000200, Code: 0x00262201, Time: ts, Values: u32s(12000, 0)},
+		{Command: 0x51800200, Code: 0x08214801, Time: ts, Values: u32s(0x00000023, 0x01000133, 0x000001c7, 0x00fffffe, 0x00fffffe, 0x00fffffe, 0x00fffffe, 0x00fffffe)},
+	} {
+		device.AddRecord(r)
+	}
+	return device
+}
+
+func TestClient(t *testing.T) {
+	device := setupDevice(t)
+	defer device.Close()
+
+	c, err := Dial(device.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("Dial returned an error: %v", err)
+	}
+	if c.Device().SUSyID != 0x8a || c.Device().Serial != 733147246 {
+		t.Errorf("Invalid device address: %+v", c.Device())
+	}
+
+	if err := c.Login(UserGroupUser, "1234"); err == nil {
+		t.Errorf("Expected error for invalid password")
+	}
+	if _, err := c.Query(QuerySpotACPower); err == nil {
+		t.Errorf("Expected error for query without session")
+	}
+	if err := c.Login(UserGroupUser, "0000"); err != nil {
+		t.Fatalf("Login returned an error: %v", err)
+	}
+
+	samples, err := c.Values(context.Background())
+	if err != nil {
+		t.Fatalf("Values returned an error: %v", err)
+	}
+	ts := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	expected := map[string]float64{
+		"GridMs.TotW":       1234,
+		"Metering.TotWhOut": 5000000,
+		"Metering.DyWhOut":  12000,
+		"DcMs.Watt[1]":      600,
+		"Operation.Health":  307,
+	}
+	if len(samples) != len(expected) {
+		t.Errorf("Expected %d samples, got %+v", len(expected), samples)
+	}
+	for _, s := range samples {
+		if v, ok := expected[s.Channel]; !ok || v != s.Value {
+			t.Errorf("Invalid sample: %+v", s)
+		}
+		if s.Device != "733147246" || !s.Time.Equal(ts) {
+			t.Errorf("Invalid device or time: %+v", s)
+		}
+	}
+
+	samples, err = c.Query(QuerySpotFrequency)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if len(samples) != 1 || samples[0].Channel != "GridMs.Hz" || samples[0].Value != 50.01 || samples[0].Unit != "Hz" {
+		t.Errorf("Invalid frequency: %+v", samples)
+	}
+
+	// the session is renewed when it expired
+	device.ExpireSession()
+	if _, err := c.Values(context.Background()); err != nil {
+		t.Errorf("Values returned an error after session expiry: %v", err)
+	}
+
+	if err := c.Logoff(); err != nil {
+		t.Errorf("Logoff returned an error: %v", err)
+	}
+	for idx := 0; idx < 100 && device.LoggedIn(); idx++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if device.LoggedIn() {
+		t.Errorf("Expected session to be closed")
+	}
+}
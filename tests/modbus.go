@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ModbusServer is a stand-in for the Modbus TCP server of an SMA device. It
+// answers reads of holding and input registers of a single unit.
+type ModbusServer struct {
+	listener net.Listener
+	UnitID   byte
+
+	mu        sync.Mutex
+	registers map[uint16]uint16
+	// delay of every response
+	delay    time.Duration
+	conns    map[net.Conn]bool
+	accepted int
+}
+
+func NewModbusServer(unitID byte) (*ModbusServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &ModbusServer{listener: listener, UnitID: unitID, registers: make(map[uint16]uint16), conns: make(map[net.Conn]bool)}
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address to connect to.
+func (s *ModbusServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *ModbusServer) Close() {
+	s.listener.Close()
+}
+
+// SetU32 stores v in the registers address and address+1.
+func (s *ModbusServer) SetU32(address uint16, v uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registers[address] = uint16(v >> 16)
+	s.registers[address+1] = uint16(v)
+}
+
+// SetU64 stores v in the four registers starting at address.
+func (s *ModbusServer) SetU64(address uint16, v uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for idx := uint16(0); idx < 4; idx++ {
+		s.registers[address+idx] = uint16(v >> (48 - 16*idx))
+	}
+}
+
+// SetDelay delays the responses by d, e.g. to let requests time out.
+func (s *ModbusServer) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Accepted returns the number of connections accepted so far.
+func (s *ModbusServer) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// DropConnections closes the open connections.
+func (s *ModbusServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *ModbusServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *ModbusServer) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, int(binary.BigEndian.Uint16(header[4:]))-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		var resp []byte
+		switch {
+		case header[6] != s.UnitID:
+			// gateway target device failed to respond
+			resp = []byte{pdu[0] | 0x80, 0x0b}
+		case (pdu[0] != 0x03 && pdu[0] != 0x04) || len(pdu) != 5:
+			resp = []byte{pdu[0] | 0x80, 0x01}
+		default:
+			resp = s.read(pdu)
+		}
+
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		time.Sleep(delay)
+
+		out := append([]byte{}, header[:4]...)
+		out = append(out, byte((len(resp)+1)>>8), byte(len(resp)+1), header[6])
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// read answers a read request, unknown registers are an illegal address.
+func (s *ModbusServer) read(pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address := binary.BigEndian.Uint16(pdu[1:])
+	count := binary.BigEndian.Uint16(pdu[3:])
+	resp := []byte{pdu[0], byte(2 * count)}
+	for idx := uint16(0); idx < count; idx++ {
+		v, ok := s.registers[address+idx]
+		if !ok {
+			return []byte{pdu[0] | 0x80, 0x02}
+		}
+		resp = append(resp, byte(v>>8), byte(v))
+	}
+	return resp
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// MQTTMessage is a message received by MQTTBroker.
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// MQTTBroker is a stand-in for an MQTT broker. It accepts all connections
+// and records the QoS 0 messages published to it.
+type MQTTBroker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []MQTTMessage
+	clients  []string
+}
+
+func NewMQTTBroker() (*MQTTBroker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &MQTTBroker{listener: listener}
+	go b.serve()
+	return b, nil
+}
+
+// URL returns the address of the broker as tcp:// URL.
+func (b *MQTTBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Messages returns the messages received so far.
+func (b *MQTTBroker) Messages() []MQTTMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]MQTTMessage(nil), b.messages...)
+}
+
+// Clients returns the client IDs of all connections so far.
+func (b *MQTTBroker) Clients() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.clients...)
+}
+
+func (b *MQTTBroker) Close() {
+	b.listener.Close()
+}
+
+func (b *MQTTBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *MQTTBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			// skip protocol name, level, flags and keep alive
+			idLen := int(binary.BigEndian.Uint16(body[10:12]))
+			b.mu.Lock()
+			b.clients = append(b.clients, string(body[12:12+idLen]))
+			b.mu.Unlock()
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			topicLen := int(binary.BigEndian.Uint16(body[0:2]))
+			b.mu.Lock()
+			b.messages = append(b.messages, MQTTMessage{
+				Topic:   string(body[2 : 2+topicLen]),
+				Payload: body[2+topicLen:],
+				Retain:  header&0x01 != 0,
+			})
+			b.mu.Unlock()
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
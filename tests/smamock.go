@@ -0,0 +1,491 @@
+package tests
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Error codes of the web interface
+const (
+	ErrCodeUnauthorized    = 401
+	ErrCodeTooManySessions = 503
+)
+
+// MockDevice is a device answering below an SMAMock, like the inverters of
+// a cluster behind one web interface.
+type MockDevice struct {
+	// Name is the key of the device in responses, usually its serial
+	Name  string
+	Files fs.FS
+	// Values and Params map keys of getValues and getParamValues to the
+	// value of each instance, e.g. {"6100_40263F00": {"1": 1234}}. Values
+	// are numbers, nil or tag lists like []map[string]int{{"tag": 307}}.
+	Values map[string]map[string]interface{}
+	Params map[string]map[string]interface{}
+	// Logger maps getLogger keys to their entries
+	Logger map[int][]types.LoggerEntry
+}
+
+// NewMockDevice returns a device with the files of fsys and typical values
+// of a string inverter.
+func NewMockDevice(name string, fsys fs.FS) *MockDevice {
+	return &MockDevice{
+		Name:  name,
+		Files: fsys,
+		Values: map[string]map[string]interface{}{
+			"6100_40263F00": {"1": 2340},
+			"6100_00465700": {"1": 5001},
+			"6380_40251E00": {"1": 1210, "2": 1190},
+			"6400_00260100": {"1": 12345678},
+			"6400_00262200": {"1": 8765},
+			"6180_08214800": {"1": []map[string]int{{"tag": 307}}},
+		},
+		Params: map[string]map[string]interface{}{
+			"6800_00832A00": {"1": 5000},
+		},
+		Logger: map[int][]types.LoggerEntry{},
+	}
+}
+
+// Fault makes requests fail. Requests are checked against the faults in the
+// order they were added.
+type Fault struct {
+	// Path is the endpoint, e.g. /dyn/getFS.json, or a prefix ending with a
+	// slash like /fs/; empty matches all requests
+	Path string
+	// Status is answered with an empty body if not 0
+	Status int
+	// Drop closes the connection without response
+	Drop bool
+	// Truncate sends half of the body, announcing the full length
+	Truncate bool
+	// Body is sent instead of the response if not empty, e.g. malformed JSON
+	Body string
+	// Delay is waited before answering
+	Delay time.Duration
+	// Expire ends all sessions before answering, as an inverter restarting
+	// in the middle of a request
+	Expire bool
+	// Skip lets that many matching requests pass before failing
+	Skip int
+	// Count limits the number of requests failed, 0 fails all
+	Count int
+}
+
+// ParseFault parses path=action[*count], action being a status code, drop,
+// truncate, malformed (JSON), expire (sessions) or a delay like 2s, e.g.
+// /dyn/getFS.json=500*3.
+func ParseFault(spec string) (Fault, error) {
+	p, action, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Fault{}, fmt.Errorf("error invalid fault %q, expected path=action", spec)
+	}
+	f := Fault{Path: p}
+	action, count, ok := strings.Cut(action, "*")
+	if ok {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return Fault{}, fmt.Errorf("error invalid fault count: %v", err)
+		}
+		f.Count = n
+	}
+	switch action {
+	case "drop":
+		f.Drop = true
+	case "truncate":
+		f.Truncate = true
+	case "malformed":
+		f.Body = `{"result":{"`
+	case "expire":
+		f.Expire = true
+	default:
+		if status, err := strconv.Atoi(action); err == nil {
+			f.Status = status
+		} else if delay, err := time.ParseDuration(action); err == nil {
+			f.Delay = delay
+		} else {
+			return Fault{}, fmt.Errorf("error invalid fault action %q", action)
+		}
+	}
+	return f, nil
+}
+
+// SMAMock is a stand-in for the web interface of SMA inverters. Unlike
+// NewMockServer it validates sessions and answers errors like an inverter.
+// Configure the exported fields before serving.
+type SMAMock struct {
+	// Users maps profiles, usr and istl, to their passwords, change them
+	// with SetPassword while serving
+	Users map[string]string
+	// MaxSessions limits the open sessions, 0 for no limit
+	MaxSessions int
+	// SessionTTL expires sessions not used for that long, 0 never
+	SessionTTL time.Duration
+	// Latency delays every response
+	Latency time.Duration
+	Devices []*MockDevice
+
+	mu       sync.Mutex
+	sessions map[string]time.Time
+	faults   []*Fault
+	now      func() time.Time
+}
+
+// NewSMAMock returns a mock with the profiles usr and istl using password
+// and at most 4 sessions, like an inverter.
+func NewSMAMock(password string, devices ...*MockDevice) *SMAMock {
+	return &SMAMock{
+		Users:       map[string]string{"usr": password, "istl": password},
+		MaxSessions: 4,
+		Devices:     devices,
+		sessions:    make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// SetPassword changes the password of the profile user.
+func (m *SMAMock) SetPassword(user, password string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Users[user] = password
+}
+
+// AddFault injects f.
+func (m *SMAMock) AddFault(f Fault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults = append(m.faults, &f)
+}
+
+// ClearFaults removes all faults.
+func (m *SMAMock) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults = nil
+}
+
+// Faults returns the number of faults not used up.
+func (m *SMAMock) Faults() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.faults)
+}
+
+// Sessions returns the number of open sessions.
+func (m *SMAMock) Sessions() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	return len(m.sessions)
+}
+
+// ExpireSessions ends all sessions, as an inverter does on restart.
+func (m *SMAMock) ExpireSessions() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = make(map[string]time.Time)
+}
+
+// expire removes idle sessions, m.mu must be held.
+func (m *SMAMock) expire() {
+	if m.SessionTTL <= 0 {
+		return
+	}
+	for sid, used := range m.sessions {
+		if m.now().Sub(used) > m.SessionTTL {
+			delete(m.sessions, sid)
+		}
+	}
+}
+
+// fault returns the fault for a request to p, counting it.
+func (m *SMAMock) fault(p string) *Fault {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for idx, f := range m.faults {
+		if f.Path == "" || f.Path == p || strings.HasSuffix(f.Path, "/") && strings.HasPrefix(p, f.Path) {
+			if f.Skip > 0 {
+				f.Skip--
+				continue
+			}
+			if f.Count > 0 {
+				f.Count--
+				if f.Count == 0 {
+					m.faults = append(m.faults[:idx:idx], m.faults[idx+1:]...)
+				}
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+// session validates the session ID of r and marks it as used.
+func (m *SMAMock) session(r *http.Request) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	sid := r.URL.Query().Get("sid")
+	if _, ok := m.sessions[sid]; !ok {
+		return false
+	}
+	m.sessions[sid] = m.now()
+	return true
+}
+
+var _ = (http.Handler)((*SMAMock)(nil))
+
+func (m *SMAMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.Latency > 0 {
+		time.Sleep(m.Latency)
+	}
+	if f := m.fault(r.URL.Path); f != nil {
+		time.Sleep(f.Delay)
+		if f.Expire {
+			m.ExpireSessions()
+		}
+		switch {
+		case f.Drop:
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		case f.Status != 0:
+			w.WriteHeader(f.Status)
+			return
+		case f.Body != "":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, f.Body)
+			return
+		case f.Truncate:
+			w = &truncatingWriter{ResponseWriter: w}
+			defer w.(*truncatingWriter).flush()
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/dyn/login.json":
+		m.login(w, r)
+	case r.URL.Path == "/dyn/logout.json":
+		m.logout(w, r)
+	case strings.HasPrefix(r.URL.Path, "/fs/"):
+		m.download(w, r)
+	case strings.HasPrefix(r.URL.Path, "/dyn/"):
+		if !m.session(r) {
+			writeJSON(w, map[string]int{"err": ErrCodeUnauthorized})
+			return
+		}
+		m.dyn(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// truncatingWriter buffers the response and sends half of it, with the
+// Content-Length of all of it.
+type truncatingWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (t *truncatingWriter) WriteHeader(status int) {
+	t.status = status
+}
+
+func (t *truncatingWriter) Write(b []byte) (int, error) {
+	t.body = append(t.body, b...)
+	return len(b), nil
+}
+
+func (t *truncatingWriter) flush() {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	t.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(t.body)))
+	t.ResponseWriter.WriteHeader(t.status)
+	t.ResponseWriter.Write(t.body[:len(t.body)/2])
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *SMAMock) login(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Right string `json:"right"`
+		Pass  string `json:"pass"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if password, ok := m.Users[request.Right]; !ok || password != request.Pass {
+		writeJSON(w, map[string]int{"err": ErrCodeUnauthorized})
+		return
+	}
+
+	m.expire()
+	if m.MaxSessions > 0 && len(m.sessions) >= m.MaxSessions {
+		writeJSON(w, map[string]int{"err": ErrCodeTooManySessions})
+		return
+	}
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	sid := hex.EncodeToString(buf)
+	m.sessions[sid] = m.now()
+	writeJSON(w, map[string]interface{}{"result": map[string]string{"sid": sid}})
+}
+
+func (m *SMAMock) logout(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	delete(m.sessions, r.URL.Query().Get("sid"))
+	m.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"result": map[string]bool{"isLogin": false}})
+}
+
+// download serves a file of the first device.
+func (m *SMAMock) download(w http.ResponseWriter, r *http.Request) {
+	if !m.session(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if len(m.Devices) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := fs.ReadFile(m.Devices[0].Files, strings.TrimPrefix(path.Clean(r.URL.Path), "/fs/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+// dyn answers the JSON endpoints for the devices in destDev, all if empty.
+func (m *SMAMock) dyn(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Devices []string `json:"destDev"`
+		Path    string   `json:"path"`
+		Keys    []string `json:"keys"`
+		Key     int      `json:"key"`
+		Start   int64    `json:"tStart"`
+		End     int64    `json:"tEnd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := make(map[string]interface{})
+	for _, d := range m.Devices {
+		if len(request.Devices) > 0 && !contains(request.Devices, d.Name) {
+			continue
+		}
+		switch r.URL.Path {
+		case "/dyn/getFS.json":
+			entries, err := listDir(d.Files, request.Path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result[d.Name] = map[string][]types.FSEntry{request.Path: entries}
+		case "/dyn/getValues.json":
+			result[d.Name] = selectValues(d.Values, request.Keys)
+		case "/dyn/getParamValues.json":
+			result[d.Name] = selectValues(d.Params, request.Keys)
+		case "/dyn/getAllParamValues.json":
+			result[d.Name] = selectValues(d.Params, nil)
+		case "/dyn/getLogger.json":
+			entries := []types.LoggerEntry{}
+			for _, entry := range d.Logger[request.Key] {
+				if int64(entry.Timestamp) >= request.Start && int64(entry.Timestamp) <= request.End {
+					entries = append(entries, entry)
+				}
+			}
+			result[d.Name] = entries
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	}
+	writeJSON(w, map[string]interface{}{"result": result})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listDir returns the entries of the directory dir of fsys.
+func listDir(fsys fs.FS, dir string) ([]types.FSEntry, error) {
+	name := strings.Trim(dir, "/")
+	if name == "" {
+		name = "."
+	}
+	content, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading content: %v", err)
+	}
+	entries := make([]types.FSEntry, 0, len(content))
+	for _, entry := range content {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if entry.IsDir() {
+			entries = append(entries, types.FSEntry{DirectoryName: entry.Name(), Timestamp: uint64(info.ModTime().Unix())})
+		} else {
+			entries = append(entries, types.FSEntry{Filename: entry.Name(), Timestamp: uint64(info.ModTime().Unix()), Size: uint64(info.Size())})
+		}
+	}
+	return entries, nil
+}
+
+// selectValues returns the values of keys, all if keys is empty, in the
+// format of getValues.
+func selectValues(values map[string]map[string]interface{}, keys []string) map[string]map[string][]map[string]interface{} {
+	if len(keys) == 0 {
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	out := make(map[string]map[string][]map[string]interface{})
+	for _, key := range keys {
+		instances, ok := values[key]
+		if !ok {
+			continue
+		}
+		out[key] = make(map[string][]map[string]interface{})
+		for instance, v := range instances {
+			out[key][instance] = []map[string]interface{}{{"val": v}}
+		}
+	}
+	return out
+}
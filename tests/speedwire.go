@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// SpeedwireRecord is a record returned by SpeedwireDevice for data queries
+// of Command covering its LRI.
+type SpeedwireRecord struct {
+	Command uint32
+	// Code holds the class, LRI and data type
+	Code   uint32
+	Time   uint32
+	Values []byte
+}
+
+// SpeedwireDevice is a stand-in for an inverter on the Speedwire network. It
+// answers discovery, identify, login, logoff and data queries on a local UDP
+// port.
+type SpeedwireDevice struct {
+	conn   *net.UDPConn
+	SUSyID uint16
+	Serial uint32
+
+	mu       sync.Mutex
+	password string
+	loggedIn bool
+	records  []SpeedwireRecord
+}
+
+func NewSpeedwireDevice(susyID uint16, serial uint32) (*SpeedwireDevice, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	d := &SpeedwireDevice{conn: conn, SUSyID: susyID, Serial: serial}
+	go d.serve()
+	return d, nil
+}
+
+// SetPassword sets the password of the user group and enables login.
+func (d *SpeedwireDevice) SetPassword(password string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.password = password
+}
+
+// AddRecord adds a record answering data queries.
+func (d *SpeedwireDevice) AddRecord(r SpeedwireRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records = append(d.records, r)
+}
+
+// LoggedIn reports whether a session is open.
+func (d *SpeedwireDevice) LoggedIn() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.loggedIn
+}
+
+// ExpireSession closes the session, data queries are rejected until the next
+// login.
+func (d *SpeedwireDevice) ExpireSession() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loggedIn = false
+}
+
+// Addr returns the address to send requests to.
+func (d *SpeedwireDevice) Addr() string {
+	return d.conn.LocalAddr().String()
+}
+
+func (d *SpeedwireDevice) Close() {
+	d.conn.Close()
+}
+
+func (d *SpeedwireDevice) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		if !bytes.HasPrefix(req, []byte("SMA\x00")) {
+			continue
+		}
+		if bytes.Equal(req[4:12], []byte{0x00, 0x04, 0x02, 0xa0, 0xff, 0xff, 0xff, 0xff}) {
+			d.conn.WriteToUDP(d.discoveryResponse(), from)
+		} else if len(req) >= 50 && binary.BigEndian.Uint16(req[16:]) == 0x6065 {
+			if resp := d.handle(req); resp != nil {
+				d.conn.WriteToUDP(resp, from)
+			}
+		}
+	}
+}
+
+// discoveryResponse is the answer of an inverter at 127.0.0.1
+func (d *SpeedwireDevice) discoveryResponse() []byte {
+	return []byte{
+		'S', 'M', 'A', 0x00,
+		0x00, 0x04, 0x02, 0xa0, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x04, 0x00, 0x10, 0x00, 0x01, 0x00, 0x03,
+		0x00, 0x04, 0x00, 0x20, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x04, 0x00, 0x30, 127, 0, 0, 1,
+		0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+// handle answers the SMA Net 2 packet req, nil drops it.
+func (d *SpeedwireDevice) handle(req []byte) []byte {
+	// command at offset 42, its parameters start at 46
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	command := binary.LittleEndian.Uint32(req[42:])
+	switch {
+	case command == 0x00000200:
+		return d.response(req, 0x00000201, 0, make([]byte, 48))
+	case command == 0xfffd040c && len(req) >= 74:
+		// user group at 46, password at 62 obfuscated by 0x88 or 0xbb
+		enc := byte(0x88)
+		if binary.LittleEndian.Uint32(req[46:]) == 0x0a {
+			enc = 0xbb
+		}
+		var password []byte
+		for _, ch := range req[62:74] {
+			if ch-enc != 0 {
+				password = append(password, ch-enc)
+			}
+		}
+		if string(password) != d.password {
+			return d.response(req, command|1, 0x0100, req[46:74])
+		}
+		d.loggedIn = true
+		return d.response(req, command|1, 0, req[46:74])
+	case command == 0xfffd010e:
+		d.loggedIn = false
+		return nil
+	case len(req) >= 58:
+		if !d.loggedIn {
+			return d.response(req, command|1, 0x0017, nil)
+		}
+		// the records of the LRIs between first and last
+		first := binary.LittleEndian.Uint32(req[46:]) & 0x00ffff00
+		last := binary.LittleEndian.Uint32(req[50:]) & 0x00ffff00
+		var records []byte
+		n := 0
+		for _, r := range d.records {
+			lri := r.Code & 0x00ffff00
+			if r.Command != command || lri < first || lri > last {
+				continue
+			}
+			var record [8]byte
+			binary.LittleEndian.PutUint32(record[:], r.Code)
+			binary.LittleEndian.PutUint32(record[4:], r.Time)
+			records = append(records, record[:]...)
+			records = append(records, r.Values...)
+			n++
+		}
+		if n == 0 {
+			return d.response(req, command|1, 0x0015, nil)
+		}
+		payload := make([]byte, 8, 8+len(records))
+		binary.LittleEndian.PutUint32(payload[4:], uint32(n-1))
+		return d.response(req, command|1, 0, append(payload, records...))
+	}
+	return nil
+}
+
+// response builds the answer to req with the given command, error code and
+// payload.
+func (d *SpeedwireDevice) response(req []byte, command uint32, code uint16, payload []byte) []byte {
+	data := make([]byte, 28, 28+len(payload))
+	data[0] = byte((28 + len(payload)) / 4)
+	data[1] = 0xe0
+	// destination is the source of the request
+	copy(data[2:10], req[28:36])
+	binary.LittleEndian.PutUint16(data[10:], d.SUSyID)
+	binary.LittleEndian.PutUint32(data[12:], d.Serial)
+	binary.LittleEndian.PutUint16(data[18:], code)
+	// packet ID
+	copy(data[22:24], req[40:42])
+	binary.LittleEndian.PutUint32(data[24:], command)
+	data = append(data, payload...)
+
+	resp := []byte{'S', 'M', 'A', 0x00, 0x00, 0x04, 0x02, 0xa0, 0x00, 0x00, 0x00, 0x01}
+	resp = append(resp, byte((2+len(data))>>8), byte(2+len(data)), 0x00, 0x10, 0x60, 0x65)
+	resp = append(resp, data...)
+	return append(resp, 0x00, 0x00, 0x00, 0x00)
+}
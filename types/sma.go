@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type FSResponse struct {
 	Devices map[string]map[string][]FSEntry `json:"result"`
 }
@@ -9,6 +14,37 @@ type FSEntry struct {
 	DirectoryName string `json:"d,omitempty"`
 	Timestamp     uint64 `json:"tm"`
 	Size          uint64 `json:"s,omitempty"`
+	// Device is the key of the device the entry was listed for
+	Device string `json:"-"`
+}
+
+type LoggerResponse struct {
+	Devices map[string][]LoggerEntry `json:"result"`
+}
+
+type LoggerEntry struct {
+	Timestamp uint64 `json:"t"`
+	// Value is nil for intervals without data
+	Value *float64 `json:"v"`
+}
+
+type ValuesResponse struct {
+	// Devices maps device, key and instance to the values
+	Devices map[string]map[string]map[string][]ValueEntry `json:"result"`
+}
+
+type ValueEntry struct {
+	// Val is a number, null or a list of tags like [{"tag":307}]
+	Val json.RawMessage `json:"val"`
 }
 
 type ApiContextKey string
+
+// Sample is a single value of a device channel at a point in time.
+type Sample struct {
+	Device  string    `json:"device"`
+	Channel string    `json:"channel"`
+	Unit    string    `json:"unit"`
+	Time    time.Time `json:"time"`
+	Value   float64   `json:"value"`
+}
@@ -0,0 +1,113 @@
+package values
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/export"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// LiveRoot is the directory containing the current values.
+const LiveRoot = "/live"
+
+// liveTTL is how long the values are reused, e.g. between the stat and
+// open issued for a single read.
+const liveTTL = 5 * time.Second
+
+// liveFile is the name of the files below LiveRoot, with an extension of
+// export.FileFormats.
+const liveFile = "values"
+
+// Live wraps a backend and adds files below LiveRoot with the current values
+// of a source.
+type Live struct {
+	*backend.Overlay
+	src Source
+	now func() time.Time
+
+	mu      sync.Mutex
+	samples []types.Sample
+	fetched time.Time
+}
+
+// NewLive returns b with the values of src added below LiveRoot.
+func NewLive(b backend.Backend, src Source) *Live {
+	l := &Live{src: src, now: time.Now}
+	l.Overlay = backend.NewOverlay(b, LiveRoot, liveDir{l}, func() time.Time { return l.now() })
+	return l
+}
+
+var _ = (backend.Backend)((*Live)(nil))
+
+// liveDir serves the files below LiveRoot, with paths relative to it.
+type liveDir struct {
+	l *Live
+}
+
+func (d liveDir) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	if name != "/" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// The size is only known once the values are fetched.
+	var entries []types.FSEntry
+	for ext := range export.FileFormats {
+		entries = append(entries, types.FSEntry{Filename: liveFile + ext, Timestamp: uint64(d.l.now().Unix())})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Filename < entries[j].Filename })
+	return entries, nil
+}
+
+func (d liveDir) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	content, fetched, err := d.l.generate(ctx, name)
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	return types.FSEntry{Filename: path.Base(name), Size: uint64(len(content)), Timestamp: uint64(fetched.Unix())}, nil
+}
+
+func (d liveDir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	content, _, err := d.l.generate(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// generate returns the content of the file name below LiveRoot and the time
+// the values were fetched.
+func (l *Live) generate(ctx context.Context, name string) ([]byte, time.Time, error) {
+	file := strings.TrimPrefix(name, "/")
+	encode, ok := export.FileFormats[path.Ext(file)]
+	if !ok || strings.TrimSuffix(file, path.Ext(file)) != liveFile {
+		return nil, time.Time{}, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.samples == nil || l.now().Sub(l.fetched) >= liveTTL {
+		samples, err := l.src.Values(ctx)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if samples == nil {
+			samples = []types.Sample{}
+		}
+		l.samples, l.fetched = samples, l.now()
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, l.samples); err != nil {
+		return nil, time.Time{}, err
+	}
+	return buf.Bytes(), l.fetched, nil
+}
@@ -0,0 +1,33 @@
+package values
+
+import (
+	"context"
+
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Source provides the current values of one or more devices.
+type Source interface {
+	Values(ctx context.Context) ([]types.Sample, error)
+}
+
+// Web reads values through the web API of an inverter.
+type Web struct {
+	api  *sma.SMAApi
+	keys []string
+}
+
+// NewWeb returns a source querying keys, or sma.DefaultKeys if empty.
+func NewWeb(api *sma.SMAApi, keys []string) *Web {
+	if len(keys) == 0 {
+		keys = sma.DefaultKeys
+	}
+	return &Web{api: api, keys: keys}
+}
+
+var _ = (Source)((*Web)(nil))
+
+func (w *Web) Values(ctx context.Context) ([]types.Sample, error) {
+	return w.api.GetValues(ctx, w.keys)
+}
@@ -0,0 +1,82 @@
+package values
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// countingSource returns a single power value and counts the calls
+type countingSource struct {
+	calls int
+}
+
+func (s *countingSource) Values(ctx context.Context) ([]types.Sample, error) {
+	s.calls++
+	return []types.Sample{{Device: "device1", Channel: "GridMs.TotW", Unit: "W", Time: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), Value: 1234}}, nil
+}
+
+func TestLive(t *testing.T) {
+	src := &countingSource{}
+	m := fstest.MapFS{"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}
+	l := NewLive(backend.NewMemory(m), src)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	entries, err := l.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[1].DirectoryName != "live" {
+		t.Errorf("Invalid root listing: %+v", entries)
+	}
+	entries, err = l.List(ctx, LiveRoot)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Filename != "values.csv" || entries[1].Filename != "values.json" {
+		t.Errorf("Invalid live listing: %+v", entries)
+	}
+
+	entry, err := l.Stat(ctx, "/live/values.csv")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	rc, err := l.Open(ctx, "/live/values.csv")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if !strings.Contains(string(content), "device1,GridMs.TotW,W,1234") {
+		t.Errorf("Invalid content: %q", content)
+	}
+	if entry.Size != uint64(len(content)) {
+		t.Errorf("Invalid size %d, expected %d", entry.Size, len(content))
+	}
+	if src.calls != 1 {
+		t.Errorf("Expected values to be reused, got %d calls", src.calls)
+	}
+
+	now = now.Add(liveTTL)
+	if _, err := l.Stat(ctx, "/live/values.json"); err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if src.calls != 2 {
+		t.Errorf("Expected values to be fetched again, got %d calls", src.calls)
+	}
+
+	if _, err := l.Stat(ctx, "/live/values.txt"); err == nil {
+		t.Errorf("Expected error for unknown file")
+	}
+	if _, err := l.Open(ctx, "/DIAGNOSE/file1.txt"); err != nil {
+		t.Errorf("Open of remote file returned an error: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package views
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/export"
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// Root is the directory containing the views.
+const Root = "/views"
+
+// cacheTTL is how long generated files are reused, e.g. between the stat and
+// open issued for a single read.
+const cacheTTL = time.Minute
+
+// view describes a directory of files with one file per period.
+type view struct {
+	// key of the logger values
+	key int
+	// layout of the file names
+	layout string
+	// interval between logged values
+	interval time.Duration
+	// count of periods listed before the current one
+	history int
+	// next returns the start of the period following start
+	next func(start time.Time) time.Time
+}
+
+var allViews = map[string]view{
+	// daily yields, one file per month
+	"yield": {key: sma.LoggerKeyDaily, layout: "2006-01", interval: 24 * time.Hour, history: 12,
+		next: func(start time.Time) time.Time { return start.AddDate(0, 1, 0) }},
+	// 5 minute yields, one file per day
+	"5min": {key: sma.LoggerKey5Min, layout: "2006-01-02", interval: 5 * time.Minute, history: 31,
+		next: func(start time.Time) time.Time { return start.AddDate(0, 0, 1) }},
+}
+
+// Views wraps a backend and adds virtual files below Root, which contain the
+// yields of a period merged from the logger.
+type Views struct {
+	*backend.Overlay
+	logger export.Logger
+	loc    *time.Location
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	content []byte
+	created time.Time
+}
+
+// New returns views backed by logger. Periods start at midnight in loc.
+func New(b backend.Backend, logger export.Logger, loc *time.Location) *Views {
+	v := &Views{logger: logger, loc: loc, now: time.Now, cache: make(map[string]cached)}
+	v.Overlay = backend.NewOverlay(b, Root, viewDir{v}, func() time.Time { return v.now() })
+	return v
+}
+
+var _ = (backend.Backend)((*Views)(nil))
+
+// viewDir serves the views below Root, with paths relative to it.
+type viewDir struct {
+	v *Views
+}
+
+// split returns the view and file name of a path relative to Root.
+func split(name string) (viewName, file string) {
+	viewName, file, _ = strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	return viewName, file
+}
+
+// period parses file name and returns the period it covers and its encoder.
+func (v *Views) period(vw view, file string) (from, to time.Time, encode func(io.Writer, []types.Sample) error, ok bool) {
+	ext := path.Ext(file)
+	encode, ok = export.FileFormats[ext]
+	if !ok {
+		return
+	}
+	from, err := time.ParseInLocation(vw.layout, strings.TrimSuffix(file, ext), v.loc)
+	if err != nil {
+		return from, to, nil, false
+	}
+	return from, vw.next(from), encode, true
+}
+
+func (v *Views) dirEntry(name string) types.FSEntry {
+	return types.FSEntry{DirectoryName: name, Timestamp: uint64(v.now().Unix())}
+}
+
+func (d viewDir) List(ctx context.Context, name string) ([]types.FSEntry, error) {
+	v := d.v
+	viewName, file := split(name)
+	if viewName == "" {
+		var entries []types.FSEntry
+		for viewName := range allViews {
+			entries = append(entries, v.dirEntry(viewName))
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].DirectoryName < entries[j].DirectoryName })
+		return entries, nil
+	}
+	vw, ok := allViews[viewName]
+	if !ok || file != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// List the most recent periods. Older ones can still be opened by name.
+	// The size is only known once a file is generated.
+	now := v.now().In(v.loc)
+	start, _ := time.ParseInLocation(vw.layout, now.Format(vw.layout), v.loc)
+	var entries []types.FSEntry
+	for idx := 0; idx <= vw.history; idx++ {
+		for ext := range export.FileFormats {
+			entries = append(entries, types.FSEntry{Filename: start.Format(vw.layout) + ext, Timestamp: uint64(modTime(vw, start, now).Unix())})
+		}
+		start = previous(vw, start)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Filename < entries[j].Filename })
+	return entries, nil
+}
+
+// previous returns the start of the period before start.
+func previous(vw view, start time.Time) time.Time {
+	prev := start.AddDate(0, 0, -1)
+	p, _ := time.ParseInLocation(vw.layout, prev.Format(vw.layout), start.Location())
+	return p
+}
+
+// modTime is the end of the period, or now for the current period.
+func modTime(vw view, start, now time.Time) time.Time {
+	if end := vw.next(start); end.Before(now) {
+		return end
+	}
+	return now
+}
+
+func (d viewDir) Stat(ctx context.Context, name string) (types.FSEntry, error) {
+	v := d.v
+	viewName, file := split(name)
+	vw, ok := allViews[viewName]
+	if !ok {
+		return types.FSEntry{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	if file == "" {
+		return v.dirEntry(viewName), nil
+	}
+
+	from, _, _, ok := v.period(vw, file)
+	if !ok {
+		return types.FSEntry{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	content, err := v.generate(ctx, name)
+	if err != nil {
+		return types.FSEntry{}, err
+	}
+	return types.FSEntry{Filename: file, Size: uint64(len(content)), Timestamp: uint64(modTime(vw, from, v.now()).Unix())}, nil
+}
+
+func (d viewDir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	content, err := d.v.generate(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// generate returns the content of the view file name, relative to Root.
+func (v *Views) generate(ctx context.Context, name string) ([]byte, error) {
+	viewName, file := split(name)
+	vw, ok := allViews[viewName]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	from, to, encode, ok := v.period(vw, file)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	v.mu.Lock()
+	c, ok := v.cache[name]
+	v.mu.Unlock()
+	if ok && v.now().Sub(c.created) < cacheTTL {
+		return c.content, nil
+	}
+
+	// The logger holds counter readings, the reading after the period
+	// is needed for the yield of its last interval
+	samples, err := v.logger.GetLogger(ctx, vw.key, from, to.Add(vw.interval))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, Yields(samples, from, to)); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, c := range v.cache {
+		if v.now().Sub(c.created) >= cacheTTL {
+			delete(v.cache, key)
+		}
+	}
+	v.cache[name] = cached{content: buf.Bytes(), created: v.now()}
+	return buf.Bytes(), nil
+}
+
+// Yields converts counter readings to the yield of each interval between two
+// readings of a device. The yield is attributed to the start of its interval,
+// only intervals starting within [from, to) are returned.
+func Yields(samples []types.Sample, from, to time.Time) []types.Sample {
+	byDevice := make(map[string][]types.Sample)
+	for _, s := range samples {
+		byDevice[s.Device] = append(byDevice[s.Device], s)
+	}
+
+	yields := []types.Sample{}
+	for device, readings := range byDevice {
+		sort.Slice(readings, func(i, j int) bool { return readings[i].Time.Before(readings[j].Time) })
+		for idx := 1; idx < len(readings); idx++ {
+			prev := readings[idx-1]
+			if prev.Time.Before(from) || !prev.Time.Before(to) {
+				continue
+			}
+			yields = append(yields, types.Sample{
+				Device:  device,
+				Channel: "Yield",
+				Unit:    prev.Unit,
+				Time:    prev.Time.UTC(),
+				Value:   readings[idx].Value - prev.Value,
+			})
+		}
+	}
+	sort.SliceStable(yields, func(i, j int) bool {
+		if !yields[i].Time.Equal(yields[j].Time) {
+			return yields[i].Time.Before(yields[j].Time)
+		}
+		return yields[i].Device < yields[j].Device
+	})
+	return yields
+}
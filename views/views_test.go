@@ -0,0 +1,131 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dominikbayerl/go-smafs/backend"
+	"github.com/dominikbayerl/go-smafs/sma"
+	"github.com/dominikbayerl/go-smafs/types"
+)
+
+// fakeLogger returns a counter increasing by 10 Wh every 5 minutes or by
+// 1000 Wh every day
+type fakeLogger struct {
+	calls int
+}
+
+func (l *fakeLogger) GetLogger(ctx context.Context, key int, from, to time.Time) ([]types.Sample, error) {
+	l.calls++
+	step, inc := 5*time.Minute, 10.0
+	if key == sma.LoggerKeyDaily {
+		step, inc = 24*time.Hour, 1000.0
+	}
+	var samples []types.Sample
+	for t := from; !t.After(to); t = t.Add(step) {
+		samples = append(samples, types.Sample{Device: "device1", Channel: "TotWhOut", Unit: "Wh", Time: t, Value: float64(t.Sub(from)/step) * inc})
+	}
+	return samples, nil
+}
+
+func setupTest() (*Views, *fakeLogger) {
+	logger := &fakeLogger{}
+	m := fstest.MapFS{"DIAGNOSE/file1.txt": &fstest.MapFile{Data: []byte("file1.txt content\n")}}
+	v := New(backend.NewMemory(m), logger, time.UTC)
+	v.now = func() time.Time { return time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC) }
+	return v, logger
+}
+
+func readAll(t *testing.T, v *Views, name string) string {
+	rc, err := v.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Open %s returned an error: %v", name, err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	return string(content)
+}
+
+func TestList(t *testing.T) {
+	v, _ := setupTest()
+	ctx := context.Background()
+
+	entries, err := v.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[1].DirectoryName != "views" {
+		t.Errorf("Invalid root listing: %+v", entries)
+	}
+
+	entries, err = v.List(ctx, "/views")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].DirectoryName != "5min" || entries[1].DirectoryName != "yield" {
+		t.Errorf("Invalid views listing: %+v", entries)
+	}
+
+	entries, err = v.List(ctx, "/views/yield")
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(entries) != 26 || entries[0].Filename != "2025-10.csv" || entries[25].Filename != "2026-10.json" {
+		t.Errorf("Invalid yield listing: %+v", entries)
+	}
+
+	// the underlying backend is still reachable
+	if _, err := v.Stat(ctx, "/DIAGNOSE/file1.txt"); err != nil {
+		t.Errorf("Stat returned an error: %v", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	v, logger := setupTest()
+
+	content := readAll(t, v, "/views/5min/2026-10-16.csv")
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) != 289 {
+		t.Fatalf("Expected 289 lines, got %d", len(lines))
+	}
+	if lines[0] != "time,device,channel,unit,value" || lines[1] != "2026-10-16T00:00:00Z,device1,Yield,Wh,10" || lines[288] != "2026-10-16T23:55:00Z,device1,Yield,Wh,10" {
+		t.Errorf("Invalid CSV: %q ... %q", lines[:2], lines[288])
+	}
+
+	entry, err := v.Stat(context.Background(), "/views/5min/2026-10-16.csv")
+	if err != nil {
+		t.Fatalf("Stat returned an error: %v", err)
+	}
+	if entry.Size != uint64(len(content)) || entry.Timestamp != uint64(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC).Unix()) {
+		t.Errorf("Invalid entry: %+v", entry)
+	}
+	if logger.calls != 1 {
+		t.Errorf("Expected generated file to be cached, got %d logger calls", logger.calls)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	v, _ := setupTest()
+
+	var samples []types.Sample
+	if err := json.Unmarshal([]byte(readAll(t, v, "/views/yield/2026-09.json")), &samples); err != nil {
+		t.Fatalf("error decoding JSON: %v", err)
+	}
+	if len(samples) != 30 || samples[0].Value != 1000 || !samples[29].Time.Equal(time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid samples: %+v", samples)
+	}
+}
+
+func TestNotExist(t *testing.T) {
+	v, _ := setupTest()
+	for _, name := range []string{"/views/hourly", "/views/yield/2026-10-01.csv", "/views/yield/2026-10.txt"} {
+		if _, err := v.Stat(context.Background(), name); err == nil {
+			t.Errorf("Expected an error for %s, but got none", name)
+		}
+	}
+}